@@ -332,50 +332,6 @@ const (
 	FinishReasonOther FinishReason = 5
 )
 
-// GenerationConfig is generation config.
-type GenerationConfig struct {
-	// Optional. Controls the randomness of predictions.
-	Temperature float32
-	// Optional. If specified, nucleus sampling will be used.
-	TopP float32
-	// Optional. If specified, top-k sampling will be used.
-	TopK float32
-	// Optional. Number of candidates to generate.
-	CandidateCount int32
-	// Optional. The maximum number of output tokens to generate per message.
-	MaxOutputTokens int32
-	// Optional. Stop sequences.
-	StopSequences []string
-}
-
-func (w *GenerationConfig) toProto() *pb.GenerationConfig {
-	if w == nil {
-		return nil
-	}
-	return &pb.GenerationConfig{
-		Temperature:     zeroToNil(w.Temperature),
-		TopP:            zeroToNil(w.TopP),
-		TopK:            zeroToNil(w.TopK),
-		CandidateCount:  zeroToNil(w.CandidateCount),
-		MaxOutputTokens: zeroToNil(w.MaxOutputTokens),
-		StopSequences:   w.StopSequences,
-	}
-}
-
-func (GenerationConfig) fromProto(p *pb.GenerationConfig) *GenerationConfig {
-	if p == nil {
-		return nil
-	}
-	return &GenerationConfig{
-		Temperature:     nilToZero(p.Temperature),
-		TopP:            nilToZero(p.TopP),
-		TopK:            nilToZero(p.TopK),
-		CandidateCount:  nilToZero(p.CandidateCount),
-		MaxOutputTokens: nilToZero(p.MaxOutputTokens),
-		StopSequences:   p.StopSequences,
-	}
-}
-
 // HarmBlockThreshold specifies probability based thresholds levels for blocking.
 type HarmBlockThreshold int32
 
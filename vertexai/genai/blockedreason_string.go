@@ -0,0 +1,36 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import "strconv"
+
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	var x [1]struct{}
+	_ = x[BlockedReasonUnspecified-0]
+	_ = x[BlockedReasonSafety-1]
+	_ = x[BlockedReasonOther-2]
+}
+
+const _BlockedReasonName = "BlockedReasonUnspecifiedBlockedReasonSafetyBlockedReasonOther"
+
+var _BlockedReasonIndex = [...]uint8{0, 24, 43, 61}
+
+func (i BlockedReason) String() string {
+	if i < 0 || i >= BlockedReason(len(_BlockedReasonIndex)-1) {
+		return "BlockedReason(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _BlockedReasonName[_BlockedReasonIndex[i]:_BlockedReasonIndex[i+1]]
+}
@@ -16,12 +16,34 @@ package genai
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 // A ChatSession provides interactive chat.
 type ChatSession struct {
 	m       *GenerativeModel
 	History []*Content
+
+	// RetryBudget, if non-nil, makes SendMessage retry calls that fail
+	// with a transient error before any response arrives, as described at
+	// [Client.SetRetry]. Each retry uses one from the budget, which is shared
+	// by all the turns of the session, and by any other sessions given the
+	// same budget. Once it is used up, errors are returned without retrying.
+	// The pauses between retries follow the client's RetryPolicy, if any,
+	// whose MaxRetries also limits the retries of each call.
+	// The budget is not used by SendMessageStream.
+	RetryBudget *RetryBudget
+
+	// GenerationConfig, if non-nil, overrides the GenerationConfig of the
+	// model for the messages of this session. Each of its fields that is not
+	// the zero value replaces the model's; the other fields of the model's
+	// config are kept. CandidateCount is always 1 in a chat.
+	GenerationConfig *GenerationConfig
 }
 
 // StartChat starts a chat session.
@@ -30,32 +52,60 @@ func (m *GenerativeModel) StartChat() *ChatSession {
 }
 
 // SendMessage sends a request to the model as part of a chat session.
+// Like [GenerativeModel.GenerateContent], it returns the partial response
+// if the deadline of ctx passes mid-stream. The partial response is not
+// added to the history.
 func (cs *ChatSession) SendMessage(ctx context.Context, parts ...Part) (*GenerateContentResponse, error) {
-	// Call the underlying client with the entire history plus the argument Content.
-	cs.History = append(cs.History, newUserContent(parts))
-	req := cs.m.newGenerateContentRequest(cs.History...)
-	cc := int32(1)
-	req.GenerationConfig.CandidateCount = &cc
-	resp, err := cs.m.generateContent(ctx, req)
+	return cs.send(ctx, newUserContent(parts))
+}
+
+// send is like SendMessage, but sends c as the next turn, with its own role.
+func (cs *ChatSession) send(ctx context.Context, c *Content) (*GenerateContentResponse, error) {
+	// Call the underlying client with the entire history plus c.
+	req, err := cs.newRequest(c)
 	if err != nil {
 		return nil, err
 	}
+	cs.History = append(cs.History, c)
+	req.budget = cs.RetryBudget
+	resp, err := cs.m.generateContent(ctx, req)
+	if err != nil {
+		// resp may hold a partial response; see GenerateContent.
+		return resp, err
+	}
 	cs.addToHistory(resp.Candidates)
 	return resp, nil
 }
 
 // SendMessageStream is like SendMessage, but with a streaming request.
 func (cs *ChatSession) SendMessageStream(ctx context.Context, parts ...Part) *GenerateContentResponseIterator {
-	cs.History = append(cs.History, newUserContent(parts))
-	req := cs.m.newGenerateContentRequest(cs.History...)
-	var cc int32 = 1
-	req.GenerationConfig.CandidateCount = &cc
-	streamClient, err := cs.m.c.c.StreamGenerateContent(ctx, req)
-	return &GenerateContentResponseIterator{
-		sc:  streamClient,
-		err: err,
-		cs:  cs,
+	c := newUserContent(parts)
+	req, err := cs.newRequest(c)
+	if err != nil {
+		return &GenerateContentResponseIterator{err: err}
+	}
+	cs.History = append(cs.History, c)
+	return cs.m.newIterator(ctx, req, cs)
+}
+
+// newRequest returns a request for the next turn of the chat, c. The history
+// is not modified, so that it is unchanged if the request cannot be made.
+func (cs *ChatSession) newRequest(c *Content) (*request, error) {
+	n := len(cs.History)
+	req, err := cs.m.newGenerateContentRequest(append(cs.History[:n:n], c)...)
+	if err != nil {
+		return nil, err
+	}
+	if o := cs.GenerationConfig.toProto(); o != nil {
+		if len(o.StopSequences) > 0 {
+			// Replace the model's, rather than appending to them.
+			req.GenerationConfig.StopSequences = nil
+		}
+		proto.Merge(req.GenerationConfig, o)
 	}
+	cc := int32(1)
+	req.GenerationConfig.CandidateCount = &cc
+	return req, nil
 }
 
 // By default, use the first candidate for history. The user can modify that if they want.
@@ -68,3 +118,99 @@ func (cs *ChatSession) addToHistory(cands []*Candidate) bool {
 	}
 	return false
 }
+
+// SaveHistory returns the History of the session encoded as JSON, for
+// restoring later with LoadHistory. Each turn is encoded as the Content
+// message of the API, so the type of each part is recorded and Blob data is
+// kept intact. The Metadata of each AnnotatedPart is encoded alongside it as a
+// JSON object, so it must be valid JSON; when loaded, its values are those
+// that [encoding/json] decodes into an any, so numbers become float64.
+func (cs *ChatSession) SaveHistory() ([]byte, error) {
+	if err := checkParts(cs.History...); err != nil {
+		return nil, err
+	}
+	turns := make([]savedTurn, len(cs.History))
+	for i, c := range cs.History {
+		b, err := protojson.Marshal(c.toProto())
+		if err != nil {
+			return nil, fmt.Errorf("genai: encoding turn %d: %w", i, err)
+		}
+		turns[i].Content = b
+		if c == nil {
+			continue
+		}
+		for j, p := range c.Parts {
+			if a, ok := p.(AnnotatedPart); ok {
+				if turns[i].Metadata == nil {
+					turns[i].Metadata = map[int]map[string]any{}
+				}
+				turns[i].Metadata[j] = a.Metadata
+			}
+		}
+	}
+	b, err := json.Marshal(turns)
+	if err != nil {
+		return nil, fmt.Errorf("genai: encoding history: %w", err)
+	}
+	return b, nil
+}
+
+// A savedTurn is the encoding of a turn of a history by SaveHistory.
+type savedTurn struct {
+	Content json.RawMessage `json:"content"`
+	// Metadata holds the metadata of the turn's AnnotatedParts, by the
+	// index of the part.
+	Metadata map[int]map[string]any `json:"metadata,omitempty"`
+}
+
+// LoadHistory replaces the History of the session with one decoded from
+// data, which was returned by SaveHistory.
+func (cs *ChatSession) LoadHistory(data []byte) error {
+	var turns []savedTurn
+	if err := json.Unmarshal(data, &turns); err != nil {
+		return fmt.Errorf("genai: decoding history: %w", err)
+	}
+	history := make([]*Content, len(turns))
+	for i, t := range turns {
+		var c pb.Content
+		if err := protojson.Unmarshal(t.Content, &c); err != nil {
+			return fmt.Errorf("genai: decoding turn %d: %w", i, err)
+		}
+		content := (Content{}).fromProto(&c)
+		for j, md := range t.Metadata {
+			if j < 0 || j >= len(content.Parts) {
+				return fmt.Errorf("genai: decoding turn %d: metadata for missing part %d", i, j)
+			}
+			content.Parts[j] = AnnotatedPart{Part: content.Parts[j], Metadata: md}
+		}
+		history[i] = content
+	}
+	cs.History = history
+	return nil
+}
+
+// MergeHistories returns the histories of sessions concatenated in order, for
+// combining the branches of a conversation.
+//
+// Adjacent turns with the same role, such as where one history ends with a
+// user turn and the next begins with one, are combined into a single turn, so
+// that roles alternate as the model requires. The sessions are not modified.
+func MergeHistories(sessions ...*ChatSession) []*Content {
+	var merged []*Content
+	for _, cs := range sessions {
+		for _, c := range cs.History {
+			if c == nil {
+				continue
+			}
+			if n := len(merged); n > 0 && merged[n-1].Role == c.Role {
+				merged[n-1].Parts = append(merged[n-1].Parts, c.Parts...)
+				continue
+			}
+			merged = append(merged, &Content{
+				Role:  c.Role,
+				Parts: append([]Part(nil), c.Parts...),
+			})
+		}
+	}
+	return merged
+}
@@ -0,0 +1,282 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMergeHistories(t *testing.T) {
+	a := &ChatSession{History: []*Content{
+		{Role: roleUser, Parts: []Part{Text("Plan a trip.")}},
+		{Role: roleModel, Parts: []Part{Text("Where to?")}},
+		{Role: roleUser, Parts: []Part{Text("Rome.")}},
+	}}
+	b := &ChatSession{History: []*Content{
+		{Role: roleUser, Parts: []Part{Text("By train.")}},
+		{Role: roleModel, Parts: []Part{Text("Take the Frecciarossa.")}},
+	}}
+	got := MergeHistories(a, &ChatSession{}, b)
+	want := []*Content{
+		{Role: roleUser, Parts: []Part{Text("Plan a trip.")}},
+		{Role: roleModel, Parts: []Part{Text("Where to?")}},
+		{Role: roleUser, Parts: []Part{Text("Rome."), Text("By train.")}},
+		{Role: roleModel, Parts: []Part{Text("Take the Frecciarossa.")}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	// The sessions are unchanged.
+	if n := len(a.History[2].Parts); n != 1 {
+		t.Errorf("first session modified: last turn has %d parts", n)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "try again")
+	srv := &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("ok"))},
+		errs:      []error{unavailable, unavailable, nil, unavailable, unavailable},
+	}
+	client := newFakeClient(t, srv)
+	// Without a retry policy, the pauses use the defaults of gax.Backoff.
+	client.clk = &fakeClock{now: time.Now()}
+	cs := client.GenerativeModel("m").StartChat()
+	cs.RetryBudget = NewRetryBudget(3)
+	ctx := context.Background()
+
+	// The first turn succeeds after two retries.
+	if _, err := cs.SendMessage(ctx, Text("one")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cs.RetryBudget.Remaining(), 1; got != want {
+		t.Errorf("after first turn: got %d retries left, want %d", got, want)
+	}
+
+	// The second turn fails after using up the budget.
+	if _, err := cs.SendMessage(ctx, Text("two")); status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v, want Unavailable", err)
+	}
+	if got := cs.RetryBudget.Remaining(); got != 0 {
+		t.Errorf("after second turn: got %d retries left, want 0", got)
+	}
+	if reqs, _ := srv.calls(); len(reqs) != 5 {
+		t.Errorf("got %d calls, want 5", len(reqs))
+	}
+
+	// The client's retry policy also limits each call.
+	client.SetRetry(&RetryPolicy{MaxRetries: 1})
+	cs.RetryBudget = NewRetryBudget(3)
+	srv.mu.Lock()
+	srv.errs = []error{unavailable, unavailable}
+	srv.mu.Unlock()
+	if _, err := cs.SendMessage(ctx, Text("three")); status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v, want Unavailable", err)
+	}
+	if got, want := cs.RetryBudget.Remaining(), 2; got != want {
+		t.Errorf("with policy: got %d retries left, want %d", got, want)
+	}
+
+	// A retry canceled during its pause does not use up the budget.
+	client.SetRetry(nil)
+	srv.mu.Lock()
+	srv.errs = []error{unavailable}
+	srv.mu.Unlock()
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	client.clk = cancelingClock{&fakeClock{now: time.Now()}, cancel}
+	if _, err := cs.SendMessage(cctx, Text("four")); err == nil {
+		t.Error("canceled: got nil, want error")
+	}
+	if got, want := cs.RetryBudget.Remaining(), 2; got != want {
+		t.Errorf("canceled: got %d retries left, want %d", got, want)
+	}
+}
+
+// cancelingClock is a fakeClock that calls cancel when asked to sleep.
+type cancelingClock struct {
+	*fakeClock
+	cancel context.CancelFunc
+}
+
+func (c cancelingClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.cancel()
+	return c.fakeClock.Sleep(ctx, d)
+}
+
+func TestChatSessionGenerationConfig(t *testing.T) {
+	srv := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("ok"))}}
+	model := newFakeClient(t, srv).GenerativeModel("m")
+	model.Temperature = Ptr[float32](0.9)
+	model.TopP = Ptr[float32](0.5)
+	model.StopSequences = []string{"END"}
+	cs := model.StartChat()
+	cs.GenerationConfig = &GenerationConfig{Temperature: Ptr[float32](0.1), StopSequences: []string{"STOP"}}
+	ctx := context.Background()
+	if _, err := cs.SendMessage(ctx, Text("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.GenerateContent(ctx, Text("hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, _ := srv.calls()
+	for i, want := range []struct {
+		temp float32
+		stop []string
+	}{
+		{0.1, []string{"STOP"}},
+		{0.9, []string{"END"}},
+	} {
+		gc := reqs[i].GenerationConfig
+		if got := gc.GetTemperature(); got != want.temp {
+			t.Errorf("call %d: got temperature %v, want %v", i, got, want.temp)
+		}
+		if got := gc.GetTopP(); got != 0.5 {
+			t.Errorf("call %d: got TopP %v, want 0.5", i, got)
+		}
+		if !reflect.DeepEqual(gc.StopSequences, want.stop) {
+			t.Errorf("call %d: got stop sequences %q, want %q", i, gc.StopSequences, want.stop)
+		}
+	}
+}
+
+func TestChatSessionHistory(t *testing.T) {
+	srv := &fakeServer{turns: [][]*pb.GenerateContentResponse{
+		{modelResponse(textPart("Rome."))},
+		{modelResponse(textPart("In ")), modelResponse(textPart("spring."))},
+	}}
+	cs := newFakeClient(t, srv).GenerativeModel("m").StartChat()
+	ctx := context.Background()
+	if _, err := cs.SendMessage(ctx, Text("Where should I go?")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := all(cs.SendMessageStream(ctx, Text("When?"))); err != nil {
+		t.Fatal(err)
+	}
+	want := []*Content{
+		{Role: roleUser, Parts: []Part{Text("Where should I go?")}},
+		{Role: roleModel, Parts: []Part{Text("Rome.")}},
+		{Role: roleUser, Parts: []Part{Text("When?")}},
+		{Role: roleModel, Parts: []Part{Text("In spring.")}},
+	}
+	if !reflect.DeepEqual(cs.History, want) {
+		t.Errorf("got %+v, want %+v", cs.History, want)
+	}
+	// The second call sent the first turn as history.
+	reqs, _ := srv.calls()
+	if n := len(reqs[1].Contents); n != 3 {
+		t.Errorf("second call: got %d contents, want 3", n)
+	}
+}
+
+func TestChatSessionModifiedFunctionCall(t *testing.T) {
+	srv := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("ok"))}}
+	cs := newFakeClient(t, srv).GenerativeModel("m").StartChat()
+	ctx := context.Background()
+	call := FunctionCall{Name: "f", Args: map[string]any{"tags": []string{"a", "b"}}}
+	cs.History = []*Content{
+		{Role: roleUser, Parts: []Part{Text("Tag it.")}},
+		{Role: roleModel, Parts: []Part{call}},
+	}
+	if _, err := cs.SendMessage(ctx, FunctionResponse{Name: "f", Response: map[string]any{"ok": true}}); err != nil {
+		t.Fatal(err)
+	}
+	reqs, _ := srv.calls()
+	got := reqs[0].Contents[1].Parts[0].GetFunctionCall().Args.AsMap()
+	if want := map[string]any{"tags": []any{"a", "b"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Args that are not JSON are an error, not a panic.
+	cs.History[1].Parts[0] = FunctionCall{Name: "f", Args: map[string]any{"c": make(chan int)}}
+	if _, err := cs.SendMessage(ctx, Text("again")); err == nil {
+		t.Error("got nil, want error")
+	}
+}
+
+func TestChatSessionBadRequest(t *testing.T) {
+	srv := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("ok"))}}
+	model := newFakeClient(t, srv).GenerativeModel("m")
+	cs := model.StartChat()
+	ctx := context.Background()
+	if _, err := cs.SendMessage(ctx, Text("hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A message that cannot be sent is not added to the history.
+	bad := FunctionResponse{Name: "f", Response: map[string]any{"c": make(chan int)}}
+	if _, err := cs.SendMessage(ctx, bad); err == nil {
+		t.Error("SendMessage: got nil, want error")
+	}
+	if _, err := cs.SendMessageStream(ctx, bad).Next(); err == nil {
+		t.Error("SendMessageStream: got nil, want error")
+	}
+	if got, want := len(cs.History), 2; got != want {
+		t.Errorf("got %d turns, want %d", got, want)
+	}
+
+	// So the next message follows the model's turn.
+	if _, err := cs.SendMessage(ctx, Text("again")); err != nil {
+		t.Fatal(err)
+	}
+	reqs, _ := srv.calls()
+	var roles []string
+	for _, c := range reqs[len(reqs)-1].Contents {
+		roles = append(roles, c.Role)
+	}
+	if want := []string{roleUser, roleModel, roleUser}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("got roles %q, want %q", roles, want)
+	}
+}
+
+func TestSaveLoadHistory(t *testing.T) {
+	cs := &ChatSession{History: []*Content{
+		{Role: roleUser, Parts: []Part{
+			Text("What is in this picture?"),
+			Blob{MIMEType: "image/png", Data: []byte("\x89PNG\r\n\x1a\n\x00\xff")},
+			FileData{MIMEType: "image/jpeg", FileURI: "gs://bucket/cat.jpg"},
+			AnnotatedPart{Part: Text("From the album."), Metadata: map[string]any{"doc": "d-17", "page": 3.0}},
+		}},
+		{Role: roleModel, Parts: []Part{Text("Two images of cats.")}},
+	}}
+	data, err := cs.SaveHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ChatSession
+	if err := got.LoadHistory(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.History, cs.History) {
+		t.Errorf("got %+v, want %+v", got.History, cs.History)
+	}
+
+	for _, bad := range []string{
+		`[{"content": {"parts": 3}}]`,
+		`[{"content": {"parts": [{"text": "a"}]}, "metadata": {"1": {}}}]`,
+	} {
+		if err := got.LoadHistory([]byte(bad)); err == nil {
+			t.Errorf("%s: got nil, want error", bad)
+		}
+	}
+}
@@ -17,17 +17,28 @@ package genai
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/civil"
+	"cloud.google.com/go/storage"
 	aiplatform "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1"
 	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	date "google.golang.org/genproto/googleapis/type/date"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // A Client is a Google Vertex AI client.
@@ -35,6 +46,24 @@ type Client struct {
 	c         *aiplatform.PredictionClient
 	projectID string
 	location  string
+	opts      []option.ClientOption // passed to NewClient
+
+	mu      sync.Mutex
+	streams map[int64]context.CancelFunc // active streams, by ID
+	nextID  int64
+	rec     *recorder    // set by RecordTo
+	retry   *RetryPolicy // set by SetRetry
+	onClose func()       // if non-nil, called by Close
+	clk     clock        // if nil, the real clock is used; see clock
+
+	// Set by SetFailoverLocations.
+	failover []string
+	// regional holds the clients for failover locations, by location.
+	regional map[string]*aiplatform.PredictionClient
+
+	// Set by SetStagingBucket.
+	staging       *storage.Client
+	stagingBucket string
 }
 
 // NewClient creates a new Google Vertex AI client.
@@ -43,10 +72,17 @@ type Client struct {
 // are safe for concurrent use by multiple goroutines.
 //
 // You may configure the client by passing in options from the [google.golang.org/api/option]
-// package.
+// package. They are applied after the default endpoint for location, so
+// [option.WithEndpoint] overrides it. Use [option.WithGRPCDialOption] to pass
+// gRPC dial options. For example, with a dialer from
+// [google.golang.org/grpc.WithContextDialer], the client can talk to an
+// in-process server on a [google.golang.org/grpc/test/bufconn] listener.
+//
+// By default, the client authenticates with Application Default Credentials.
+// To use a service account key instead, pass [option.WithCredentialsJSON]
+// with its contents, or [option.WithCredentialsFile] with its path.
 func NewClient(ctx context.Context, projectID, location string, opts ...option.ClientOption) (*Client, error) {
-	apiEndpoint := fmt.Sprintf("%s-aiplatform.googleapis.com:443", location)
-	c, err := aiplatform.NewPredictionClient(ctx, option.WithEndpoint(apiEndpoint))
+	c, err := newPredictionClient(ctx, location, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -54,12 +90,97 @@ func NewClient(ctx context.Context, projectID, location string, opts ...option.C
 		c:         c,
 		projectID: projectID,
 		location:  location,
+		opts:      opts,
 	}, nil
 }
 
+// newPredictionClient creates a client for the endpoint of location.
+func newPredictionClient(ctx context.Context, location string, opts []option.ClientOption) (*aiplatform.PredictionClient, error) {
+	return aiplatform.NewPredictionClient(ctx, clientOptions(location, opts)...)
+}
+
+// clientOptions returns the options for a client for location: the default
+// endpoint of location, followed by opts, so that opts override it.
+func clientOptions(location string, opts []option.ClientOption) []option.ClientOption {
+	apiEndpoint := fmt.Sprintf("%s-aiplatform.googleapis.com:443", location)
+	return append([]option.ClientOption{option.WithEndpoint(apiEndpoint)}, opts...)
+}
+
 // Close closes the client.
 func (c *Client) Close() error {
-	return c.c.Close()
+	err := c.c.Close()
+	c.mu.Lock()
+	for _, pc := range c.regional {
+		if cerr := pc.Close(); err == nil {
+			err = cerr
+		}
+	}
+	c.regional = nil
+	c.mu.Unlock()
+	if c.onClose != nil {
+		c.onClose()
+	}
+	return err
+}
+
+// ProjectID returns the project ID the client was created with.
+func (c *Client) ProjectID() string {
+	return c.projectID
+}
+
+// Location returns the location the client was created with.
+func (c *Client) Location() string {
+	return c.location
+}
+
+// ActiveStreams returns the number of streaming calls in progress, including
+// those made by GenerateContent and SendMessage.
+// A stream is active until its iterator returns an error or [iterator.Done],
+// until it is canceled, or until the context it was started with is done,
+// even if its iterator is abandoned.
+func (c *Client) ActiveStreams() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.streams)
+}
+
+// CancelStreams cancels all the streaming calls in progress. Their iterators
+// will return errors. It can be used to drain a client before closing it.
+func (c *Client) CancelStreams() {
+	c.mu.Lock()
+	streams := c.streams
+	c.streams = nil
+	c.mu.Unlock()
+	for _, cancel := range streams {
+		cancel()
+	}
+}
+
+// addStream tracks the stream with context ctx, returning a function that
+// cancels it and stops tracking it. The stream is no longer tracked once ctx is
+// done.
+func (c *Client) addStream(ctx context.Context, cancel context.CancelFunc) context.CancelFunc {
+	c.mu.Lock()
+	if c.streams == nil {
+		c.streams = map[int64]context.CancelFunc{}
+	}
+	id := c.nextID
+	c.nextID++
+	c.streams[id] = cancel
+	c.mu.Unlock()
+	remove := func() {
+		c.mu.Lock()
+		delete(c.streams, id)
+		c.mu.Unlock()
+	}
+	go func() {
+		<-ctx.Done()
+		remove()
+	}()
+	return func() {
+		cancel()
+		remove()
+	}
 }
 
 // GenerativeModel is a model that can generate text.
@@ -68,13 +189,70 @@ func (c *Client) Close() error {
 //
 // The model holds all the config for a GenerateContentRequest, so the GenerateContent method
 // can use a vararg for the content.
+//
+// A model may be used by multiple goroutines at once. To change its config
+// while it is in use, call [GenerativeModel.Configure].
 type GenerativeModel struct {
 	c        *Client
 	name     string
 	fullName string
 
+	// mu guards the exported fields against changes made with Configure.
+	// Each call reads them under mu, so it sees a consistent snapshot.
+	mu sync.RWMutex
+
 	GenerationConfig
 	SafetySettings []*SafetySetting
+	Tools          []*Tool
+
+	// ServerTimeout, if positive, limits the time the service spends
+	// processing each call, independently of the deadline of the call's
+	// context. It is sent in the X-Server-Timeout header.
+	//
+	// The deadline of the context is always sent to the service, in the
+	// grpc-timeout header, so ServerTimeout is only needed to make the
+	// service give up sooner than the client would.
+	ServerTimeout time.Duration
+
+	// FallbackModel, if non-empty, is the name of a model to use when this
+	// one is out of quota. If a call made by GenerateContent or SendMessage
+	// fails with code ResourceExhausted before any response arrives, the
+	// same request is sent once more to the fallback model. Streaming calls
+	// do not fall back.
+	FallbackModel string
+
+	// TrafficType, if set, selects the kind of capacity that serves calls
+	// for customers with Provisioned Throughput. It is sent in the
+	// X-Vertex-AI-LLM-Request-Type header. If it is empty, calls use
+	// Provisioned Throughput first and spill over to on-demand capacity.
+	TrafficType TrafficType
+
+	// KeepEmptyText, if true, sends Text parts that are empty. By default,
+	// they are removed from requests, since they only add to their size and
+	// the service may reject them. Other kinds of parts are always sent.
+	KeepEmptyText bool
+
+	// SystemInstruction, if non-nil, holds instructions that apply to all
+	// the calls made with the model, like "You are a pirate." Its Role is
+	// ignored.
+	//
+	// The version of the API used by this package has no separate system
+	// instruction, so its parts are sent at the start of the first user
+	// turn of each request. They count towards the tokens of the prompt.
+	SystemInstruction *Content
+
+	// ReturnBlockedCandidates, if true, makes calls return responses with
+	// candidates that were blocked for safety, leaving it to the caller to
+	// check their FinishReason. By default, such a response is an error of
+	// type *BlockedError, even if other candidates were not blocked.
+	ReturnBlockedCandidates bool
+
+	// EmptyResponseRetries is the number of times a call made by
+	// GenerateContent or SendMessage is sent again when it succeeds with an
+	// empty response: one with no candidates, or whose candidates have no
+	// content. Asking again often yields content. These retries are separate
+	// from those of [Client.SetRetry]. Streaming calls are not retried.
+	EmptyResponseRetries int
 }
 
 const defaultMaxOutputTokens = 2048
@@ -84,69 +262,600 @@ func (c *Client) GenerativeModel(name string) *GenerativeModel {
 	return &GenerativeModel{
 		GenerationConfig: GenerationConfig{
 			MaxOutputTokens: defaultMaxOutputTokens,
-			TopK:            3,
+			TopK:            Ptr[float32](3),
 		},
 		c:        c,
 		name:     name,
-		fullName: fmt.Sprintf("projects/%s/locations/%s/publishers/google/models/%s", c.projectID, c.location, name),
+		fullName: c.fullModelName(name),
 	}
 }
 
+// Configure calls f to change the configuration of m, in a way that is safe
+// while other goroutines are making calls with m. Calls in flight are not
+// affected; calls started after Configure returns see all the changes made
+// by f. Setting the exported fields of m directly is only safe when no calls
+// are being made.
+//
+// f is called with m locked, so it must not call methods of m, such as Clone
+// or GenerateContent; doing so deadlocks.
+func (m *GenerativeModel) Configure(f func(m *GenerativeModel)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(m)
+}
+
+// Clone returns a copy of m that shares no configuration with it: changing
+// the GenerationConfig, SafetySettings, Tools or SystemInstruction of the
+// copy, or of anything they point to, does not affect m. The copy uses the
+// same Client as m.
+func (m *GenerativeModel) Clone() *GenerativeModel {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	gc := m.GenerationConfig
+	gc.Temperature = clonePtr(gc.Temperature)
+	gc.TopP = clonePtr(gc.TopP)
+	gc.TopK = clonePtr(gc.TopK)
+	gc.StopSequences = append([]string(nil), gc.StopSequences...)
+	c := &GenerativeModel{
+		c:                       m.c,
+		name:                    m.name,
+		fullName:                m.fullName,
+		GenerationConfig:        gc,
+		ServerTimeout:           m.ServerTimeout,
+		FallbackModel:           m.FallbackModel,
+		TrafficType:             m.TrafficType,
+		KeepEmptyText:           m.KeepEmptyText,
+		ReturnBlockedCandidates: m.ReturnBlockedCandidates,
+		EmptyResponseRetries:    m.EmptyResponseRetries,
+	}
+	for _, s := range m.SafetySettings {
+		c.SafetySettings = append(c.SafetySettings, clonePtr(s))
+	}
+	for _, t := range m.Tools {
+		c.Tools = append(c.Tools, (Tool{}).fromProto(proto.Clone(t.toProto()).(*pb.Tool)))
+	}
+	c.SystemInstruction = cloneContent(m.SystemInstruction)
+	return c
+}
+
+// fullModelName returns the resource name of the model with the given name.
+func (c *Client) fullModelName(name string) string {
+	return fmt.Sprintf("projects/%s/locations/%s/publishers/google/models/%s", c.projectID, c.location, name)
+}
+
 // Name returns the name of the model.
 func (m *GenerativeModel) Name() string {
 	return m.name
 }
 
 // GenerateContent produces a single request and response.
+//
+// If the deadline of ctx passes after part of the response has been
+// received, GenerateContent returns the partial response along with an
+// error that wraps [context.DeadlineExceeded].
 func (m *GenerativeModel) GenerateContent(ctx context.Context, parts ...Part) (*GenerateContentResponse, error) {
-	return m.generateContent(ctx, m.newGenerateContentRequest(newUserContent(parts)))
+	req, err := m.newGenerateContentRequest(newUserContent(parts))
+	if err != nil {
+		return nil, err
+	}
+	return m.generateContent(ctx, req)
+}
+
+// GenerateContentFromStrings is like GenerateContent, but for a conversation
+// given as texts of alternating turns, starting and ending with the user:
+// turns[0] is from the user, turns[1] from the model, and so on. There must
+// be an odd number of turns.
+func (m *GenerativeModel) GenerateContentFromStrings(ctx context.Context, turns ...string) (*GenerateContentResponse, error) {
+	if len(turns)%2 == 0 {
+		return nil, fmt.Errorf("genai: got %d turns, want an odd number ending with the user", len(turns))
+	}
+	contents := make([]*Content, len(turns))
+	for i, t := range turns {
+		role := roleUser
+		if i%2 == 1 {
+			role = roleModel
+		}
+		contents[i] = &Content{Role: role, Parts: []Part{Text(t)}}
+	}
+	req, err := m.newGenerateContentRequest(contents...)
+	if err != nil {
+		return nil, err
+	}
+	return m.generateContent(ctx, req)
+}
+
+// GenerateContentFromContents is like GenerateContent, but for a
+// conversation given as contents, which are sent in order with the roles they
+// have. It allows a request to hold turns that GenerateContent cannot make,
+// like a model turn with a FunctionCall followed by a "function" turn with the
+// FunctionResponse.
+func (m *GenerativeModel) GenerateContentFromContents(ctx context.Context, contents ...*Content) (*GenerateContentResponse, error) {
+	if len(contents) == 0 {
+		return nil, errors.New("genai: no contents")
+	}
+	req, err := m.newGenerateContentRequest(contents...)
+	if err != nil {
+		return nil, err
+	}
+	return m.generateContent(ctx, req)
+}
+
+// GenerateContentWithUsage is like GenerateContent, but also returns the
+// number of tokens used by the call. The usage comes from the UsageMetadata
+// reported in the response, so no separate call to CountTokens is made.
+// The streamed usage is cumulative; the last one reported is returned.
+// It is nil if the service did not report usage.
+func (m *GenerativeModel) GenerateContentWithUsage(ctx context.Context, parts ...Part) (*GenerateContentResponse, *UsageMetadata, error) {
+	resp, err := m.GenerateContent(ctx, parts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, resp.UsageMetadata, nil
 }
 
 // GenerateContentStream returns an iterator that enumerates responses.
 func (m *GenerativeModel) GenerateContentStream(ctx context.Context, parts ...Part) *GenerateContentResponseIterator {
-	streamClient, err := m.c.c.StreamGenerateContent(ctx, m.newGenerateContentRequest(newUserContent(parts)))
-	return &GenerateContentResponseIterator{
-		sc:  streamClient,
-		err: err,
+	req, err := m.newGenerateContentRequest(newUserContent(parts))
+	if err != nil {
+		return &GenerateContentResponseIterator{err: err}
 	}
+	return m.newIterator(ctx, req, nil)
 }
 
-func (m *GenerativeModel) generateContent(ctx context.Context, req *pb.GenerateContentRequest) (*GenerateContentResponse, error) {
-	streamClient, err := m.c.c.StreamGenerateContent(ctx, req)
-	iter := &GenerateContentResponseIterator{
-		sc:  streamClient,
-		err: err,
+// CallOptions are options for a single call to generate content.
+type CallOptions struct {
+	// Timeout, if positive, limits how long the call may take. For a
+	// streaming call, it limits the time until the first response arrives;
+	// once it has, the stream is not limited.
+	// A call that times out returns an error wrapping context.DeadlineExceeded.
+	Timeout time.Duration
+}
+
+// GenerateContentWithOptions is like GenerateContent, with options for this call.
+func (m *GenerativeModel) GenerateContentWithOptions(ctx context.Context, opts CallOptions, parts ...Part) (*GenerateContentResponse, error) {
+	if opts.Timeout <= 0 {
+		return m.GenerateContent(ctx, parts...)
+	}
+	ctx, cancel := m.c.clock().WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	resp, err := m.GenerateContent(ctx, parts...)
+	// The service may give up first, since the deadline is sent to it.
+	timedOut := ctx.Err() == context.DeadlineExceeded || status.Code(err) == codes.DeadlineExceeded
+	if err != nil && timedOut && !errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+	}
+	return resp, err
+}
+
+// GenerateContentStreamWithOptions is like GenerateContentStream, with options
+// for this call.
+func (m *GenerativeModel) GenerateContentStreamWithOptions(ctx context.Context, opts CallOptions, parts ...Part) *GenerateContentResponseIterator {
+	if opts.Timeout <= 0 {
+		return m.GenerateContentStream(ctx, parts...)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	fd := &firstResponseDeadline{cancel: cancel}
+	fd.stopTimer = m.c.clock().AfterFunc(opts.Timeout, fd.expire)
+	iter := m.GenerateContentStream(ctx, parts...)
+	if iter.err != nil {
+		// The call failed to start.
+		fd.stop()
+		cancel()
+		return iter
+	}
+	iter.firstResponse = fd
+	iterCancel := iter.cancel
+	iter.cancel = func() {
+		iterCancel()
+		cancel()
+	}
+	return iter
+}
+
+// A firstResponseDeadline cancels a streaming call if its first response
+// does not arrive in time.
+type firstResponseDeadline struct {
+	cancel    context.CancelFunc
+	stopTimer func() bool
+
+	mu      sync.Mutex
+	stopped bool
+	expired bool
+}
+
+func (d *firstResponseDeadline) expire() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.stopped {
+		d.expired = true
+		d.cancel()
+	}
+}
+
+// stop stops the timer, reporting whether it had already expired.
+func (d *firstResponseDeadline) stop() bool {
+	d.stopTimer()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopped = true
+	return d.expired
+}
+
+func (m *GenerativeModel) generateContent(ctx context.Context, req *request) (*GenerateContentResponse, error) {
+	fallback := req.fallbackModel
+	for i := 0; ; i++ {
+		resp, err := m.generateContentOnce(ctx, req)
+		if err != nil && resp == nil && fallback != "" && status.Code(err) == codes.ResourceExhausted {
+			req = &request{
+				GenerateContentRequest: proto.Clone(req.GenerateContentRequest).(*pb.GenerateContentRequest),
+				callSettings:           req.callSettings,
+				budget:                 req.budget,
+			}
+			req.Model = m.c.fullModelName(fallback)
+			fallback = ""
+			resp, err = m.generateContentOnce(ctx, req)
+		}
+		if err != nil || i >= req.emptyResponseRetries || !isEmptyResponse(resp) {
+			return resp, err
+		}
+	}
+}
+
+// isEmptyResponse reports whether resp has no candidate with content, not
+// counting empty Text parts.
+func isEmptyResponse(resp *GenerateContentResponse) bool {
+	if resp == nil {
+		return true
+	}
+	for _, c := range resp.Candidates {
+		if c.Content == nil {
+			continue
+		}
+		for _, p := range c.Content.Parts {
+			if t, ok := p.(Text); !ok || t != "" {
+				return false
+			}
+		}
 	}
+	return true
+}
+
+func (m *GenerativeModel) generateContentOnce(ctx context.Context, req *request) (*GenerateContentResponse, error) {
+	iter := m.newIterator(ctx, req, nil)
 	for {
 		_, err := iter.Next()
 		if err == iterator.Done {
 			return iter.merged, nil
 		}
 		if err != nil {
+			// If the deadline passed mid-stream, return what arrived so far.
+			if iter.merged != nil && isDeadlineExceeded(ctx, err) {
+				return iter.merged, fmt.Errorf("%w: response is incomplete: %w", context.DeadlineExceeded, err)
+			}
 			return nil, err
 		}
 	}
 }
 
-func (m *GenerativeModel) newGenerateContentRequest(contents ...*Content) *pb.GenerateContentRequest {
-	return &pb.GenerateContentRequest{
-		Model:            m.fullName,
-		Contents:         mapSlice(contents, (*Content).toProto),
-		SafetySettings:   mapSlice(m.SafetySettings, (*SafetySetting).toProto),
-		GenerationConfig: m.GenerationConfig.toProto(),
+// isDeadlineExceeded reports whether err is the result of a deadline passing,
+// either the one of ctx or one reported by the service.
+func isDeadlineExceeded(ctx context.Context, err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) ||
+		ctx.Err() == context.DeadlineExceeded ||
+		status.Code(err) == codes.DeadlineExceeded
+}
+
+// A request is a GenerateContentRequest together with the settings of the
+// model that control how the call is made. Both are read from the model under
+// one lock, so that a concurrent Configure cannot mix old and new settings
+// within a call.
+type request struct {
+	*pb.GenerateContentRequest
+	callSettings
+
+	// If non-nil, each retry of the call uses one from budget.
+	budget *RetryBudget
+}
+
+// callSettings are the settings of a model that control how a call is made,
+// rather than what is sent.
+type callSettings struct {
+	serverTimeout        time.Duration
+	trafficType          TrafficType
+	fallbackModel        string
+	emptyResponseRetries int
+	returnBlocked        bool
+}
+
+// callSettings returns the call settings of m. m.mu must be held.
+func (m *GenerativeModel) callSettings() callSettings {
+	return callSettings{
+		serverTimeout:        m.ServerTimeout,
+		trafficType:          m.TrafficType,
+		fallbackModel:        m.FallbackModel,
+		emptyResponseRetries: m.EmptyResponseRetries,
+		returnBlocked:        m.ReturnBlockedCandidates,
 	}
 }
 
+func (m *GenerativeModel) newGenerateContentRequest(contents ...*Content) (*request, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := checkFunctionNames(m.Tools); err != nil {
+		return nil, err
+	}
+	if err := checkParts(contents...); err != nil {
+		return nil, err
+	}
+	if err := checkParts(m.SystemInstruction); err != nil {
+		return nil, err
+	}
+	pbContents := m.withSystemInstruction(mapSlice(contents, (*Content).toProto))
+	if !m.KeepEmptyText {
+		pruneEmptyText(pbContents)
+	}
+	return &request{
+		GenerateContentRequest: &pb.GenerateContentRequest{
+			Model:            m.fullName,
+			Contents:         pbContents,
+			SafetySettings:   mapSlice(m.SafetySettings, (*SafetySetting).toProto),
+			GenerationConfig: m.GenerationConfig.toProto(),
+			Tools:            mapSlice(m.Tools, (*Tool).toProto),
+		},
+		callSettings: m.callSettings(),
+	}, nil
+}
+
+// pruneEmptyText removes the empty Text parts of contents, in place.
+func pruneEmptyText(contents []*pb.Content) {
+	for _, c := range contents {
+		if c == nil {
+			continue
+		}
+		parts := c.Parts[:0]
+		for _, p := range c.Parts {
+			if t, ok := p.Data.(*pb.Part_Text); ok && t.Text == "" {
+				continue
+			}
+			parts = append(parts, p)
+		}
+		c.Parts = parts
+	}
+}
+
+// withSystemInstruction returns contents with the parts of the model's
+// system instruction added to the first user turn. m.mu must be held.
+func (m *GenerativeModel) withSystemInstruction(contents []*pb.Content) []*pb.Content {
+	if m.SystemInstruction == nil {
+		return contents
+	}
+	return prependToFirstUserTurn(contents, m.SystemInstruction.Parts)
+}
+
+// prependToFirstUserTurn returns contents with parts added to the start of the
+// first content with the user role. The contents are not modified.
+func prependToFirstUserTurn(contents []*pb.Content, parts []Part) []*pb.Content {
+	if len(parts) == 0 {
+		return contents
+	}
+	for i, c := range contents {
+		if c.Role != roleUser {
+			continue
+		}
+		out := append([]*pb.Content(nil), contents...)
+		out[i] = &pb.Content{
+			Role:  c.Role,
+			Parts: append(mapSlice(parts, partToProto), c.Parts...),
+		}
+		return out
+	}
+	return contents
+}
+
+// HashRequest returns a hash of the request that GenerateContent would send
+// for parts. Equal requests have equal hashes, so the hash can serve as a
+// cache key or idempotency key.
+//
+// The hash depends on the model's name and configuration as well as on
+// parts. It is stable for a given version of this package, but may change
+// between versions.
+func (m *GenerativeModel) HashRequest(parts ...Part) (string, error) {
+	req, err := m.newGenerateContentRequest(newUserContent(parts))
+	if err != nil {
+		return "", err
+	}
+	return hashRequest(req.GenerateContentRequest)
+}
+
+// hashRequest returns the hex-encoded SHA-256 hash of the deterministic
+// serialization of req.
+func hashRequest(req *pb.GenerateContentRequest) (string, error) {
+	bytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkFunctionNames reports an error if two function declarations,
+// in the same Tool or in different ones, have the same name.
+// The model could not tell them apart.
+func checkFunctionNames(tools []*Tool) error {
+	seen := map[string]bool{}
+	for _, t := range tools {
+		if t == nil {
+			continue
+		}
+		for _, fd := range t.FunctionDeclarations {
+			if fd == nil {
+				continue
+			}
+			if seen[fd.Name] {
+				return fmt.Errorf("genai: duplicate function name %q in Tools", fd.Name)
+			}
+			seen[fd.Name] = true
+		}
+	}
+	return nil
+}
+
+// newIterator starts a streaming call for req. If cs is non-nil, the merged
+// response is added to its history when the stream ends.
+func (m *GenerativeModel) newIterator(ctx context.Context, req *request, cs *ChatSession) *GenerateContentResponseIterator {
+	ctx, cancel := context.WithCancel(req.rpcContext(ctx))
+	cancel = m.c.addStream(ctx, cancel)
+	retry := m.c.newRetryer(req.budget)
+	iter := &GenerateContentResponseIterator{
+		cs:       cs,
+		cancel:   cancel,
+		req:      req.GenerateContentRequest,
+		rec:      m.c.recorder(),
+		ctx:      ctx,
+		retry:    retry,
+		pc:       m.c.c,
+		c:        m.c,
+		failover: m.c.failoverLocations(),
+
+		returnBlocked:   req.returnBlocked,
+		maxOutputTokens: req.GetGenerationConfig().GetMaxOutputTokens(),
+	}
+	streamClient, err := iter.pc.StreamGenerateContent(ctx, iter.req)
+	for err != nil && (iter.failOver(err) || retry.retry(ctx, err)) {
+		streamClient, err = iter.pc.StreamGenerateContent(ctx, iter.req)
+	}
+	iter.sc = streamClient
+	iter.err = err
+	if err != nil {
+		cancel()
+		iter.record(err)
+		iter.err = modelNotFound(req.Model, err)
+	}
+	return iter
+}
+
+// rpcContext adds the request headers of s to ctx.
+func (s callSettings) rpcContext(ctx context.Context) context.Context {
+	if s.serverTimeout > 0 {
+		secs := strconv.FormatFloat(s.serverTimeout.Seconds(), 'f', -1, 64)
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-server-timeout", secs)
+	}
+	if s.trafficType != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-vertex-ai-llm-request-type", string(s.trafficType))
+	}
+	return ctx
+}
+
+// TrafficType is the kind of capacity that serves a call.
+type TrafficType string
+
+const (
+	// TrafficTypeDedicated uses only Provisioned Throughput. Calls fail with
+	// code ResourceExhausted when it is used up.
+	TrafficTypeDedicated TrafficType = "dedicated"
+	// TrafficTypeShared uses only on-demand capacity, even when Provisioned
+	// Throughput is available.
+	TrafficTypeShared TrafficType = "shared"
+)
+
 func newUserContent(parts []Part) *Content {
 	return &Content{Role: roleUser, Parts: parts}
 }
 
+// ErrFunctionCall is returned by [GenerateContentResponseIterator.Next] after
+// a response containing a FunctionCall, if StopAtFunctionCall is set.
+var ErrFunctionCall = errors.New("genai: stopped at function call")
+
+// ErrOutputTokenLimit is returned by [GenerateContentResponseIterator.Next]
+// after the response that went past AbortAfterOutputTokens.
+var ErrOutputTokenLimit = errors.New("genai: stopped at output token limit")
+
+// ErrOutputCharLimit is returned by [GenerateContentResponseIterator.Next]
+// after the response that was truncated at MaxOutputChars.
+var ErrOutputCharLimit = errors.New("genai: stopped at output character limit")
+
 // GenerateContentResponseIterator is an iterator over GnerateContentResponse.
 type GenerateContentResponseIterator struct {
+	// StopAtFunctionCall makes the iterator stop as soon as a response
+	// containing a FunctionCall has been returned, instead of reading the
+	// rest of the stream. The following call to Next returns ErrFunctionCall.
+	// It must be set before the first call to Next.
+	StopAtFunctionCall bool
+
+	// DisableTextMerging keeps the Text parts of each response separate when
+	// responses are merged, instead of concatenating adjacent ones. The
+	// merged response, and the history of a ChatSession, then preserve the
+	// chunk boundaries of the stream.
+	// It must be set before the first call to Next.
+	DisableTextMerging bool
+
+	// AbortAfterOutputTokens, if positive, makes the iterator stop as soon
+	// as a response brings the number of output tokens generated past it,
+	// even if MaxOutputTokens has not been reached. The stream is canceled,
+	// and the following call to Next returns ErrOutputTokenLimit. The count
+	// is that of RemainingOutputTokens, so it is estimated until the service
+	// reports usage.
+	// It must be set before the first call to Next.
+	AbortAfterOutputTokens int32
+
+	// MaxOutputChars, if positive, limits the number of characters (runes)
+	// of text of each candidate. The response that goes past it is
+	// truncated to the limit, dropping any parts after the cut, and is the
+	// last one: the stream is canceled, and the following call to Next
+	// returns ErrOutputCharLimit.
+	// It must be set before the first call to Next.
+	MaxOutputChars int
+
+	// OnOutputTokens, if non-nil, is called by Next after each response with
+	// the running count of output tokens for the first candidate, for
+	// displaying costs live. Until the service reports usage, the count is
+	// estimated with EstimateTokens over the text of each response, and
+	// exact is false. Once usage is reported, usually with the last
+	// response, the count is the service's, and exact is true.
+	OnOutputTokens func(count int32, exact bool)
+
+	// OnHeartbeat, if non-nil, is called every HeartbeatInterval while the
+	// first call to Next waits for the first response, for example to send
+	// keepalives through proxies that close idle connections. It is called
+	// on a separate goroutine, never after Next returns.
+	// It must be set before the first call to Next.
+	OnHeartbeat func()
+	// HeartbeatInterval is the interval at which OnHeartbeat is called.
+	// If it is not positive, OnHeartbeat is not called.
+	HeartbeatInterval time.Duration
+
 	sc     pb.PredictionService_StreamGenerateContentClient
 	err    error
 	merged *GenerateContentResponse
 	cs     *ChatSession
+	cancel context.CancelFunc
+	// If rec is non-nil, the request and raw responses are recorded to it
+	// when the stream ends.
+	req *pb.GenerateContentRequest
+	rec *recorder
+	raw []*pb.GenerateContentResponse
+	// estimate is the running estimate of output tokens.
+	estimate int32
+	// requestID is set by readRequestID.
+	requestID string
+	// maxOutputTokens is the MaxOutputTokens of the request, or 0 if unset.
+	maxOutputTokens int32
+	// If retry is non-nil, the call is made again on pc with ctx if it
+	// fails before any response arrives.
+	ctx   context.Context
+	retry *retryer
+	pc    *aiplatform.PredictionClient
+	// failover holds the locations of c that the call has yet to fail over
+	// to; see failOver.
+	c        *Client
+	failover []string
+	// returnBlocked is the ReturnBlockedCandidates of the model.
+	returnBlocked bool
+	// chars is the number of characters of text of each candidate so far,
+	// by index, when MaxOutputChars is set.
+	chars map[int32]int
+	// firstResponse, if non-nil, is stopped when Recv first returns.
+	firstResponse *firstResponseDeadline
 }
 
 // Next returns the next response.
@@ -154,68 +863,217 @@ func (iter *GenerateContentResponseIterator) Next() (*GenerateContentResponse, e
 	if iter.err != nil {
 		return nil, iter.err
 	}
+	stopHeartbeat := iter.startHeartbeat()
 	resp, err := iter.sc.Recv()
+	for err != nil && err != io.EOF && iter.merged == nil && (iter.failOver(err) || iter.retry.retry(iter.ctx, err)) {
+		iter.sc, err = iter.pc.StreamGenerateContent(iter.ctx, iter.req)
+		if err == nil {
+			resp, err = iter.sc.Recv()
+		}
+	}
+	stopHeartbeat()
+	if iter.firstResponse != nil {
+		if iter.firstResponse.stop() && err != nil && err != io.EOF {
+			err = fmt.Errorf("%w: no response within the timeout: %w", context.DeadlineExceeded, err)
+		}
+		iter.firstResponse = nil
+	}
 	iter.err = err
 	if err == io.EOF {
-		if iter.cs != nil && iter.merged != nil {
-			iter.cs.addToHistory(iter.merged.Candidates)
-		}
+		iter.finish(iterator.Done)
 		return nil, iterator.Done
 	}
 	if err != nil {
+		iter.cancel()
+		iter.record(err)
+		if iter.merged == nil {
+			err = modelNotFound(iter.req.Model, err)
+		}
+		if id := iter.readRequestID(); id != "" {
+			err = &requestIDError{requestID: id, err: err}
+		}
+		iter.err = err
 		return nil, err
 	}
-	gcp, err := protoToResponse(resp)
+	if iter.rec != nil {
+		iter.raw = append(iter.raw, resp)
+	}
+	gcp, err := protoToResponse(resp, iter.returnBlocked)
 	if err != nil {
 		iter.err = err
+		iter.cancel()
+		iter.record(nil)
 		return nil, err
 	}
+	gcp.RequestID = iter.readRequestID()
+	truncated := iter.truncateChars(gcp)
 	// Merge this response in with the ones we've already seen.
-	iter.merged = joinResponses(iter.merged, gcp)
-	// If this is part of a ChatSession, remember the response for the history.
+	// Merge a copy, so the response returned to the caller is never modified.
+	iter.merged = joinResponses(iter.merged, copyResponse(gcp), !iter.DisableTextMerging)
+	iter.countOutputTokens(gcp)
+	if truncated {
+		iter.finish(ErrOutputCharLimit)
+	} else if iter.StopAtFunctionCall && hasFunctionCall(gcp) {
+		iter.finish(ErrFunctionCall)
+	} else if n, _ := iter.outputTokens(); iter.AbortAfterOutputTokens > 0 && n > iter.AbortAfterOutputTokens {
+		iter.finish(ErrOutputTokenLimit)
+	}
 	return gcp, nil
 }
 
+// MergedResponse returns the result of merging all the responses returned by
+// Next so far. It is nil if Next has not returned a response.
+func (iter *GenerateContentResponseIterator) MergedResponse() *GenerateContentResponse {
+	return iter.merged
+}
+
+// finish ends the stream, making err the result of subsequent calls to Next.
+func (iter *GenerateContentResponseIterator) finish(err error) {
+	iter.err = err
+	iter.cancel()
+	iter.record(nil)
+	// If this is part of a ChatSession, remember the response for the history.
+	if iter.cs != nil && iter.merged != nil {
+		iter.cs.addToHistory(iter.merged.Candidates)
+	}
+}
+
+// record records the call, if the iterator is recording, with rpcErr, the
+// error that ended the call. The call is recorded only once.
+func (iter *GenerateContentResponseIterator) record(rpcErr error) {
+	if iter.rec == nil {
+		return
+	}
+	iter.rec.record(iter.req, iter.raw, rpcErr)
+	iter.rec = nil
+	iter.raw = nil
+}
+
+func hasFunctionCall(resp *GenerateContentResponse) bool {
+	for _, c := range resp.Candidates {
+		if c.Content == nil {
+			continue
+		}
+		for _, p := range c.Content.Parts {
+			if _, ok := p.(FunctionCall); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // GenerateContentResponse is the response from a GenerateContent or GenerateContentStream call.
 type GenerateContentResponse struct {
 	Candidates     []*Candidate
 	PromptFeedback *PromptFeedback
+	UsageMetadata  *UsageMetadata
+	// RequestID is the ID the service assigned to the call, if it sent one.
+	// See [GenerateContentResponseIterator.RequestID].
+	RequestID string
+	// SoftBlockWarning is non-nil if the safety filters flagged the prompt
+	// without blocking it.
+	SoftBlockWarning *SoftBlockWarning
+}
+
+// A SoftBlockWarning reports that the prompt was rated at least
+// HarmProbabilityLow in some category, but not blocked. Unlike a
+// BlockedError, the response is still returned.
+type SoftBlockWarning struct {
+	// SafetyRatings are the ratings of the prompt.
+	SafetyRatings []*SafetyRating
 }
 
-func protoToResponse(resp *pb.GenerateContentResponse) (*GenerateContentResponse, error) {
-	// Assume a non-nil PromptFeedback is an error.
-	// TODO: confirm.
+// protoToResponse converts resp, returning an error if the prompt or a
+// candidate was blocked. If returnBlocked is true, candidates blocked for
+// safety are returned instead.
+func protoToResponse(resp *pb.GenerateContentResponse, returnBlocked bool) (*GenerateContentResponse, error) {
+	// PromptFeedback may be present without the prompt being blocked; it is
+	// an error only if it gives a reason for blocking.
 	pf := (PromptFeedback{}).fromProto(resp.PromptFeedback)
+	var warning *SoftBlockWarning
 	if pf != nil {
-		return nil, &BlockedError{PromptFeedback: pf}
+		if pf.BlockReason != BlockedReasonUnspecified {
+			return nil, &BlockedError{PromptFeedback: pf}
+		}
+		if isFlagged(pf) {
+			warning = &SoftBlockWarning{SafetyRatings: pf.SafetyRatings}
+		}
 	}
 	cands := mapSlice(resp.Candidates, (Candidate{}).fromProto)
-	// If any candidate is blocked, error.
-	// TODO: is this too harsh?
+	// If any candidate is blocked, error, unless the caller wants to check.
 	for _, c := range cands {
-		if c.FinishReason == FinishReasonSafety {
+		if c.FinishReason == FinishReasonSafety && !returnBlocked {
 			return nil, &BlockedError{Candidate: c}
 		}
 	}
-	return &GenerateContentResponse{Candidates: cands}, nil
+	// Likewise if any candidate was stopped for reciting its sources.
+	for _, c := range cands {
+		if c.FinishReason == FinishReasonRecitation {
+			return nil, &RecitationError{Candidate: c, CitationMetadata: c.CitationMetadata}
+		}
+	}
+	return &GenerateContentResponse{
+		Candidates:       cands,
+		PromptFeedback:   pf,
+		UsageMetadata:    (UsageMetadata{}).fromProto(resp.UsageMetadata),
+		SoftBlockWarning: warning,
+	}, nil
+}
+
+// isFlagged reports whether any safety rating of pf is HarmProbabilityLow
+// or higher.
+func isFlagged(pf *PromptFeedback) bool {
+	for _, r := range pf.SafetyRatings {
+		if r.Probability >= HarmProbabilityLow {
+			return true
+		}
+	}
+	return false
 }
 
 // CountTokens counts the number of tokens in the content.
 func (m *GenerativeModel) CountTokens(ctx context.Context, parts ...Part) (*CountTokensResponse, error) {
-	req := m.newCountTokensRequest(newUserContent(parts))
-	res, err := m.c.c.CountTokens(ctx, req)
+	return m.countTokens(ctx, true, newUserContent(parts))
+}
+
+// countTokens counts the tokens in contents, along with those of the model's
+// SystemInstruction if withSystem is true.
+func (m *GenerativeModel) countTokens(ctx context.Context, withSystem bool, contents ...*Content) (*CountTokensResponse, error) {
+	req, settings, err := m.newCountTokensRequest(withSystem, contents...)
 	if err != nil {
 		return nil, err
 	}
+	ctx = settings.rpcContext(ctx)
+	retry := m.c.newRetryer(nil)
+	res, err := m.c.c.CountTokens(ctx, req)
+	for err != nil && retry.retry(ctx, err) {
+		res, err = m.c.c.CountTokens(ctx, req)
+	}
+	if err != nil {
+		return nil, modelNotFound(req.Model, err)
+	}
 	return (CountTokensResponse{}).fromProto(res), nil
 }
 
-func (m *GenerativeModel) newCountTokensRequest(contents ...*Content) *pb.CountTokensRequest {
+func (m *GenerativeModel) newCountTokensRequest(withSystem bool, contents ...*Content) (*pb.CountTokensRequest, callSettings, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := checkParts(contents...); err != nil {
+		return nil, callSettings{}, err
+	}
+	pcs := mapSlice(contents, (*Content).toProto)
+	if withSystem {
+		if err := checkParts(m.SystemInstruction); err != nil {
+			return nil, callSettings{}, err
+		}
+		pcs = m.withSystemInstruction(pcs)
+	}
 	return &pb.CountTokensRequest{
 		Endpoint: m.fullName,
 		Model:    m.fullName,
-		Contents: mapSlice(contents, (*Content).toProto),
-	}
+		Contents: pcs,
+	}, m.callSettings(), nil
 }
 
 // A BlockedError indicates that the model's response was blocked.
@@ -244,37 +1102,138 @@ func (e *BlockedError) Error() string {
 	return b.String()
 }
 
+// A RecitationError indicates that the model stopped generating a candidate
+// because the output too closely recited existing content.
+// It is distinct from a BlockedError, which is about safety.
+type RecitationError struct {
+	// The candidate that was stopped.
+	Candidate *Candidate
+
+	// The sources the candidate recited, if the model reported them.
+	CitationMetadata *CitationMetadata
+}
+
+func (e *RecitationError) Error() string {
+	return fmt.Sprintf("recitation: candidate %d: %s", e.Candidate.Index, e.Candidate.FinishReason)
+}
+
+// modelsDocURL lists the models that are available.
+const modelsDocURL = "https://cloud.google.com/vertex-ai/docs/generative-ai/learn/models"
+
+// A ModelNotFoundError indicates that the service does not know the model
+// that a call named, often because of a typo in the name.
+type ModelNotFoundError struct {
+	// Name is the full resource name of the model, like
+	// "projects/P/locations/L/publishers/google/models/gemini-pro".
+	Name string
+	// Err is the error returned by the service, with code NotFound.
+	Err error
+}
+
+func (e *ModelNotFoundError) Error() string {
+	return fmt.Sprintf("genai: model %s not found (for available models, see %s): %v", e.Name, modelsDocURL, e.Err)
+}
+
+func (e *ModelNotFoundError) Unwrap() error { return e.Err }
+
+// modelNotFound returns err as a ModelNotFoundError for model, if it is the
+// service reporting the model as not found. Otherwise, it returns err.
+func modelNotFound(model string, err error) error {
+	s, ok := status.FromError(err)
+	if !ok || s.Code() != codes.NotFound || !strings.Contains(strings.ToLower(s.Message()), "model") {
+		return err
+	}
+	return &ModelNotFoundError{Name: model, Err: err}
+}
+
+// copyResponse returns a copy of r that can be merged into without
+// modifying r. The parts themselves are not copied.
+func copyResponse(r *GenerateContentResponse) *GenerateContentResponse {
+	c := *r
+	c.Candidates = make([]*Candidate, len(r.Candidates))
+	for i, cand := range r.Candidates {
+		cc := *cand
+		if cand.Content != nil {
+			content := *cand.Content
+			content.Parts = append([]Part(nil), cand.Content.Parts...)
+			cc.Content = &content
+		}
+		if cand.CitationMetadata != nil {
+			cm := *cand.CitationMetadata
+			cm.Citations = append([]*Citation(nil), cand.CitationMetadata.Citations...)
+			cc.CitationMetadata = &cm
+		}
+		c.Candidates[i] = &cc
+	}
+	return &c
+}
+
 // joinResponses  merges the two responses, which should be the result of a streaming call.
-// The first argument is modified.
-func joinResponses(dest, src *GenerateContentResponse) *GenerateContentResponse {
+// The first argument is modified. If mergeTexts is true, adjacent Text parts
+// are concatenated.
+func joinResponses(dest, src *GenerateContentResponse, mergeTexts bool) *GenerateContentResponse {
 	if dest == nil {
 		return src
 	}
-	dest.Candidates = joinCandidateLists(dest.Candidates, src.Candidates)
-	// Keep dest.PromptFeedback.
-	// TODO: Take the last UsageMetadata.
+	dest.Candidates = joinCandidateLists(dest.Candidates, src.Candidates, mergeTexts)
+	// Keep dest.PromptFeedback and dest.SoftBlockWarning.
+	// The usage is cumulative, so take the last one.
+	if src.UsageMetadata != nil {
+		dest.UsageMetadata = src.UsageMetadata
+	}
 	return dest
 }
 
-func joinCandidateLists(dest, src []*Candidate) []*Candidate {
-	indexToSrcCandidate := map[int32]*Candidate{}
-	for _, s := range src {
-		indexToSrcCandidate[s.Index] = s
-	}
+// joinCandidateLists merges the candidates of src into dest, matching them by
+// Index. Chunks of different candidates may be interleaved in a stream, so a
+// candidate may first appear in a later chunk. The result is sorted by Index.
+func joinCandidateLists(dest, src []*Candidate, mergeTexts bool) []*Candidate {
+	indexToDestCandidate := map[int32]*Candidate{}
 	for _, d := range dest {
-		s := indexToSrcCandidate[d.Index]
-		if s != nil {
-			d.Content = joinContent(d.Content, s.Content)
-			// Take the last of these.
-			d.FinishReason = s.FinishReason
-			// d.FinishMessage = s.FinishMessage
-			d.SafetyRatings = s.SafetyRatings
-			d.CitationMetadata = joinCitationMetadata(d.CitationMetadata, s.CitationMetadata)
+		indexToDestCandidate[d.Index] = d
+	}
+	for _, s := range src {
+		d := indexToDestCandidate[s.Index]
+		if d == nil {
+			// This is the first chunk of the candidate.
+			dest = append(dest, s)
+			indexToDestCandidate[s.Index] = s
+			continue
 		}
+		d.Content = joinContent(d.Content, s.Content, mergeTexts)
+		// Take the last of these.
+		d.FinishReason = s.FinishReason
+		if s.FinishMessage != "" {
+			d.FinishMessage = s.FinishMessage
+		}
+		d.SafetyRatings = joinSafetyRatings(d.SafetyRatings, s.SafetyRatings)
+		d.CitationMetadata = joinCitationMetadata(d.CitationMetadata, s.CitationMetadata)
 	}
+	sort.SliceStable(dest, func(i, j int) bool { return dest[i].Index < dest[j].Index })
 	return dest
 }
 
+// joinSafetyRatings returns dest updated with the ratings in src. A rating in
+// src replaces the one in dest with the same category, since ratings can
+// change as more content is generated. Neither argument is modified.
+func joinSafetyRatings(dest, src []*SafetyRating) []*SafetyRating {
+	if len(src) == 0 {
+		return dest
+	}
+	res := append([]*SafetyRating(nil), dest...)
+outer:
+	for _, s := range src {
+		for i, d := range res {
+			if d.Category == s.Category {
+				res[i] = s
+				continue outer
+			}
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
 func joinCitationMetadata(dest, src *CitationMetadata) *CitationMetadata {
 	if dest == nil {
 		return src
@@ -286,17 +1245,25 @@ func joinCitationMetadata(dest, src *CitationMetadata) *CitationMetadata {
 	return dest
 }
 
-func joinContent(dest, src *Content) *Content {
+func joinContent(dest, src *Content, mergeTexts bool) *Content {
 	if dest == nil {
 		return src
 	}
 	// Assume roles are the same.
-	dest.Parts = joinParts(dest.Parts, src.Parts)
+	dest.Parts = joinParts(dest.Parts, src.Parts, mergeTexts)
 	return dest
 }
 
-func joinParts(dest, src []Part) []Part {
-	return mergeTexts(append(dest, src...))
+// joinParts returns the parts of dest followed by those of src, with adjacent
+// Text parts merged if merge is true. Neither argument is modified.
+func joinParts(dest, src []Part, merge bool) []Part {
+	// Limit the capacity of dest so that append copies it instead of
+	// writing into an array that other slices may share.
+	parts := append(dest[:len(dest):len(dest)], src...)
+	if !merge {
+		return parts
+	}
+	return mergeTexts(parts)
 }
 
 func mergeTexts(in []Part) []Part {
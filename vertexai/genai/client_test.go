@@ -21,14 +21,29 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/internal/testutil"
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	gax "github.com/googleapis/gax-go/v2"
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 var (
@@ -49,7 +64,7 @@ func TestLive(t *testing.T) {
 	}
 	defer client.Close()
 	model := client.GenerativeModel(*modelName)
-	model.Temperature = 0
+	model.Temperature = Ptr[float32](0)
 
 	t.Run("GenerateContent", func(t *testing.T) {
 		resp, err := model.GenerateContent(ctx, Text("What is the average size of a swallow?"))
@@ -113,7 +128,7 @@ func TestLive(t *testing.T) {
 
 	t.Run("image", func(t *testing.T) {
 		vmodel := client.GenerativeModel(*modelName + "-vision")
-		vmodel.Temperature = 0
+		vmodel.Temperature = Ptr[float32](0)
 
 		data, err := os.ReadFile(filepath.Join("testdata", imageFile))
 		if err != nil {
@@ -168,7 +183,7 @@ func TestLive(t *testing.T) {
 	})
 	t.Run("max-tokens", func(t *testing.T) {
 		maxModel := client.GenerativeModel(*modelName)
-		maxModel.Temperature = 0
+		maxModel.Temperature = Ptr[float32](0)
 		maxModel.MaxOutputTokens = 10
 		res, err := maxModel.GenerateContent(ctx, Text("What is a dog?"))
 		if err != nil {
@@ -182,7 +197,7 @@ func TestLive(t *testing.T) {
 	})
 	t.Run("max-tokens-streaming", func(t *testing.T) {
 		maxModel := client.GenerativeModel(*modelName)
-		maxModel.Temperature = 0
+		maxModel.Temperature = Ptr[float32](0)
 		maxModel.MaxOutputTokens = 10
 		iter := maxModel.GenerateContentStream(ctx, Text("What is a dog?"))
 		var merged *GenerateContentResponse
@@ -194,7 +209,7 @@ func TestLive(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			merged = joinResponses(merged, res)
+			merged = joinResponses(merged, res, true)
 		}
 		want := FinishReasonMaxTokens
 		if got := merged.Candidates[0].FinishReason; got != want && got != FinishReasonOther { // TODO: see above
@@ -236,7 +251,6 @@ func TestJoinResponses(t *testing.T) {
 				FinishReason: FinishReason(3),
 			},
 			{
-				// ignored
 				Index:        1,
 				Content:      &Content{Role: roleModel, Parts: []Part{Text(";r2 i1")}},
 				FinishReason: FinishReason(4),
@@ -245,19 +259,24 @@ func TestJoinResponses(t *testing.T) {
 
 		PromptFeedback: &PromptFeedback{BlockReasonMessage: "br2"},
 	}
-	got := joinResponses(r1, r2)
+	got := joinResponses(r1, r2, true)
 	want := &GenerateContentResponse{
 		Candidates: []*Candidate{
-			{
-				Index:        2,
-				Content:      &Content{Role: roleModel, Parts: []Part{Text("r1 i2")}},
-				FinishReason: FinishReason(1),
-			},
 			{
 				Index:        0,
 				Content:      &Content{Role: roleModel, Parts: []Part{Text("r1 i0;r2 i0")}},
 				FinishReason: FinishReason(3),
 			},
+			{
+				Index:        1,
+				Content:      &Content{Role: roleModel, Parts: []Part{Text(";r2 i1")}},
+				FinishReason: FinishReason(4),
+			},
+			{
+				Index:        2,
+				Content:      &Content{Role: roleModel, Parts: []Part{Text("r1 i2")}},
+				FinishReason: FinishReason(1),
+			},
 		},
 		PromptFeedback: &PromptFeedback{BlockReasonMessage: "br1"},
 	}
@@ -266,6 +285,118 @@ func TestJoinResponses(t *testing.T) {
 	}
 }
 
+func TestJoinInterleavedCandidates(t *testing.T) {
+	chunk := func(index int32, text string, fr FinishReason) *GenerateContentResponse {
+		return &GenerateContentResponse{Candidates: []*Candidate{{
+			Index:        index,
+			Content:      &Content{Role: roleModel, Parts: []Part{Text(text)}},
+			FinishReason: fr,
+		}}}
+	}
+	var got *GenerateContentResponse
+	for _, c := range []*GenerateContentResponse{
+		chunk(1, "B1 ", 0),
+		chunk(0, "A1 ", 0),
+		chunk(1, "B2 ", 0),
+		chunk(0, "A2 ", 0),
+		chunk(0, "A3", FinishReasonStop),
+		chunk(1, "B3", FinishReasonMaxTokens),
+	} {
+		got = joinResponses(got, copyResponse(c), true)
+	}
+	want := &GenerateContentResponse{Candidates: []*Candidate{
+		{Index: 0, Content: &Content{Role: roleModel, Parts: []Part{Text("A1 A2 A3")}}, FinishReason: FinishReasonStop},
+		{Index: 1, Content: &Content{Role: roleModel, Parts: []Part{Text("B1 B2 B3")}}, FinishReason: FinishReasonMaxTokens},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot  %s\nwant %s", responseString(got), responseString(want))
+	}
+}
+
+func TestJoinChunkBoundaries(t *testing.T) {
+	for _, test := range []struct {
+		chunks [][]Part
+		want   []Part
+	}{
+		{
+			chunks: [][]Part{{Text("Hello")}, {Text(" ")}, {Text("world")}},
+			want:   []Part{Text("Hello world")},
+		},
+		{
+			chunks: [][]Part{{Text("Hello ")}, {Text(" ")}, {Text(" world ")}},
+			want:   []Part{Text("Hello   world ")},
+		},
+		{
+			chunks: [][]Part{{Text("Hel"), Text("lo")}, {Text(" "), Blob{"b", nil}}, {Text("world")}},
+			want:   []Part{Text("Hello "), Blob{"b", nil}, Text("world")},
+		},
+	} {
+		var got *GenerateContentResponse
+		for _, c := range test.chunks {
+			got = joinResponses(got, &GenerateContentResponse{Candidates: []*Candidate{{
+				Content: &Content{Role: roleModel, Parts: c},
+			}}}, true)
+		}
+		if !reflect.DeepEqual(got.Candidates[0].Content.Parts, test.want) {
+			t.Errorf("%q: got %q, want %q", test.chunks, got.Candidates[0].Content.Parts, test.want)
+		}
+	}
+
+	// The parts of a stream are merged the same way.
+	srv := &fakeServer{responses: []*pb.GenerateContentResponse{
+		modelResponse(textPart("Hello")),
+		modelResponse(textPart(" ")),
+		modelResponse(textPart("world")),
+	}}
+	resp, err := newFakeClient(t, srv).GenerativeModel("m").GenerateContent(context.Background(), Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Candidates[0].Content.Parts, []Part{Text("Hello world")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Joining does not write into the array of dest, which other slices
+	// may share.
+	dest := make([]Part, 1, 4)
+	dest[0] = Text("Hello")
+	a := joinParts(dest, []Part{Text(" world")}, false)
+	joinParts(dest, []Part{Text("!")}, false)
+	if want := []Part{Text("Hello"), Text(" world")}; !reflect.DeepEqual(a, want) {
+		t.Errorf("got %q, want %q", a, want)
+	}
+}
+
+func TestJoinFinishMessage(t *testing.T) {
+	finish := modelResponse(textPart(" world"))
+	finish.Candidates[0].FinishReason = pb.Candidate_OTHER
+	finish.Candidates[0].FinishMessage = proto.String("stopped for a reason")
+	// A later chunk for the candidate with an empty message does not clear
+	// it.
+	last := modelResponse(textPart("!"))
+	last.Candidates[0].FinishReason = pb.Candidate_OTHER
+	last.Candidates[0].FinishMessage = proto.String("")
+	srv := &fakeServer{responses: []*pb.GenerateContentResponse{
+		modelResponse(textPart("Hello")),
+		finish,
+		last,
+	}}
+	resp, err := newFakeClient(t, srv).GenerativeModel("m").GenerateContent(context.Background(), Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := resp.Candidates[0]
+	if got, want := c.Text(), "Hello world!"; got != want {
+		t.Errorf("got text %q, want %q", got, want)
+	}
+	if got, want := c.FinishMessage, "stopped for a reason"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := c.FinishReason, FinishReasonOther; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
 func TestMergeTexts(t *testing.T) {
 	for _, test := range []struct {
 		in   []Part
@@ -295,6 +426,1303 @@ func TestMergeTexts(t *testing.T) {
 	}
 }
 
+func TestRecitationError(t *testing.T) {
+	_, err := protoToResponse(&pb.GenerateContentResponse{
+		Candidates: []*pb.Candidate{{
+			Index:        0,
+			Content:      &pb.Content{Role: roleModel, Parts: []*pb.Part{{Data: &pb.Part_Text{Text: "It was the best of times"}}}},
+			FinishReason: pb.Candidate_RECITATION,
+			CitationMetadata: &pb.CitationMetadata{
+				Citations: []*pb.Citation{{StartIndex: 0, EndIndex: 24, Uri: "https://example.com/two-cities"}},
+			},
+		}},
+	}, false)
+	var berr *BlockedError
+	if errors.As(err, &berr) {
+		t.Fatalf("got BlockedError %v, want RecitationError", err)
+	}
+	var rerr *RecitationError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v (%[1]T), want RecitationError", err)
+	}
+	if rerr.Candidate == nil || rerr.Candidate.FinishReason != FinishReasonRecitation {
+		t.Errorf("got candidate %+v, want one with FinishReasonRecitation", rerr.Candidate)
+	}
+	if rerr.CitationMetadata == nil || len(rerr.CitationMetadata.Citations) != 1 {
+		t.Fatalf("got citations %+v, want one", rerr.CitationMetadata)
+	}
+	if got, want := rerr.CitationMetadata.Citations[0].URI, "https://example.com/two-cities"; got != want {
+		t.Errorf("got URI %q, want %q", got, want)
+	}
+}
+
+func TestFunctionCalling(t *testing.T) {
+	args, err := structpb.NewStruct(map[string]any{"location": "Paris"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := &pb.Part{Data: &pb.Part_FunctionCall{FunctionCall: &pb.FunctionCall{Name: "get_weather", Args: args}}}
+	fake := &fakeServer{responses: []*pb.GenerateContentResponse{
+		modelResponse(textPart("Let me ")),
+		modelResponse(textPart("check."), call),
+		modelResponse(textPart("One moment.")),
+	}}
+	model := newFakeClient(t, fake).GenerativeModel("m")
+	model.Tools = []*Tool{{FunctionDeclarations: []*FunctionDeclaration{{
+		Name:        "get_weather",
+		Description: "Returns the weather in a location.",
+		Parameters: &Schema{
+			Type:       TypeObject,
+			Properties: map[string]*Schema{"location": {Type: TypeString}},
+			Required:   []string{"location"},
+		},
+	}}}}
+	cs := model.StartChat()
+	ctx := context.Background()
+	resp, err := cs.SendMessage(ctx, Text("What's the weather in Paris?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Merging texts across chunks does not swallow the call or reorder it.
+	wantParts := []Part{
+		Text("Let me check."),
+		FunctionCall{Name: "get_weather", Args: map[string]any{"location": "Paris"}},
+		Text("One moment."),
+	}
+	if got := resp.Candidates[0].Content.Parts; !reflect.DeepEqual(got, wantParts) {
+		t.Errorf("got parts %#v, want %#v", got, wantParts)
+	}
+
+	_, err = cs.SendMessage(ctx, FunctionResponse{Name: "get_weather", Response: map[string]any{"temp": 21.0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs, _ := fake.calls()
+	decl := reqs[0].Tools[0].FunctionDeclarations[0]
+	if decl.Name != "get_weather" || decl.Parameters.Properties["location"].Type != pb.Type_STRING {
+		t.Errorf("bad function declaration in request: %v", decl)
+	}
+	contents := reqs[1].Contents
+	if n := len(contents); n != 3 {
+		t.Fatalf("got %d contents, want 3", n)
+	}
+	if fc := contents[1].Parts[1].GetFunctionCall(); fc.GetName() != "get_weather" {
+		t.Errorf("history: got %v, want the function call", contents[1].Parts[1])
+	}
+	if fr := contents[2].Parts[0].GetFunctionResponse(); fr.GetName() != "get_weather" || fr.Response.Fields["temp"].GetNumberValue() != 21 {
+		t.Errorf("got %v, want the function response", contents[2].Parts[0])
+	}
+}
+
+func TestStopAtFunctionCall(t *testing.T) {
+	ctx := context.Background()
+	call := &pb.Part{Data: &pb.Part_FunctionCall{FunctionCall: &pb.FunctionCall{Name: "get_weather"}}}
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			modelResponse(textPart("Let me check.")),
+			modelResponse(call),
+			modelResponse(textPart("never read")),
+		},
+	})
+	model := client.GenerativeModel("m")
+	cs := model.StartChat()
+	iter := cs.SendMessageStream(ctx, Text("What's the weather?"))
+	iter.StopAtFunctionCall = true
+
+	var got []*GenerateContentResponse
+	for {
+		resp, err := iter.Next()
+		if err == ErrFunctionCall {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, resp)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d responses, want 2", len(got))
+	}
+	if _, ok := got[1].Candidates[0].Content.Parts[0].(FunctionCall); !ok {
+		t.Errorf("got %v, want a FunctionCall", got[1].Candidates[0].Content.Parts[0])
+	}
+	if _, err := iter.Next(); err != ErrFunctionCall {
+		t.Errorf("got %v, want ErrFunctionCall", err)
+	}
+	// The partial response, with the call, is in the history.
+	if g, w := len(cs.History), 2; g != w {
+		t.Fatalf("history length: got %d, want %d", g, w)
+	}
+	want := []Part{Text("Let me check."), FunctionCall{Name: "get_weather", Args: map[string]any{}}}
+	if got := cs.History[1].Parts; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDisableTextMerging(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			modelResponse(textPart("Hel")),
+			modelResponse(textPart("lo"), textPart(",")),
+			modelResponse(textPart(" world")),
+		},
+	})
+	model := client.GenerativeModel("m")
+	for _, test := range []struct {
+		disable bool
+		want    []Part
+	}{
+		{false, []Part{Text("Hello, world")}},
+		{true, []Part{Text("Hel"), Text("lo"), Text(","), Text(" world")}},
+	} {
+		iter := model.GenerateContentStream(ctx, Text("hi"))
+		iter.DisableTextMerging = test.disable
+		chunks, err := all(iter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The chunks are as received, whether or not texts are merged.
+		var gotChunks []string
+		for _, c := range chunks {
+			gotChunks = append(gotChunks, contentString(c.Candidates[0].Content))
+		}
+		if want := []string{"Hel", "lo;,", " world"}; !reflect.DeepEqual(gotChunks, want) {
+			t.Errorf("disable=%t: chunks: got %q, want %q", test.disable, gotChunks, want)
+		}
+		if got := iter.MergedResponse().Candidates[0].Content.Parts; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("disable=%t: merged: got %q, want %q", test.disable, got, test.want)
+		}
+	}
+}
+
+func TestPartialResponseOnDeadline(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			modelResponse(textPart("Once upon")),
+			modelResponse(textPart(" a time")),
+		},
+		wait: true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	resp, err := client.GenerativeModel("m").GenerateContent(ctx, Text("Tell me a story."))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want an error wrapping context.DeadlineExceeded", err)
+	}
+	if resp == nil {
+		t.Fatal("got nil response, want partial response")
+	}
+	if got, want := responseString(resp), "Once upon a time"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestActiveStreams(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("hi"))},
+		wait:      true,
+	})
+	model := client.GenerativeModel("m")
+	if got := client.ActiveStreams(); got != 0 {
+		t.Fatalf("got %d active streams, want 0", got)
+	}
+	iters := []*GenerateContentResponseIterator{
+		model.GenerateContentStream(ctx, Text("a")),
+		model.GenerateContentStream(ctx, Text("b")),
+		model.StartChat().SendMessageStream(ctx, Text("c")),
+	}
+	for _, iter := range iters {
+		if _, err := iter.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := client.ActiveStreams(); got != 3 {
+		t.Errorf("got %d active streams, want 3", got)
+	}
+	client.CancelStreams()
+	if got := client.ActiveStreams(); got != 0 {
+		t.Errorf("after cancel: got %d active streams, want 0", got)
+	}
+	for _, iter := range iters {
+		if _, err := iter.Next(); status.Code(err) != codes.Canceled {
+			t.Errorf("got %v, want Canceled", err)
+		}
+	}
+}
+
+func TestAbandonedStream(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("hi"))},
+		wait:      true,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	iter := client.GenerativeModel("m").GenerateContentStream(ctx, Text("a"))
+	if _, err := iter.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.ActiveStreams(); got != 1 {
+		t.Fatalf("got %d active streams, want 1", got)
+	}
+	// The iterator is not used again, but the stream stops being tracked
+	// once its context is done.
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for client.ActiveStreams() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream still active after its context was canceled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	fake := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("hi"))}}
+	model := newFakeClient(t, fake).GenerativeModel("m")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if _, err := model.GenerateContent(ctx, Text("hi")); err != nil {
+		t.Fatal(err)
+	}
+	model.ServerTimeout = 1500 * time.Millisecond
+	if _, err := model.GenerateContent(ctx, Text("hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, contexts := fake.calls()
+	for i, want := range [][]string{nil, {"1.5"}} {
+		sctx := contexts[i]
+		// The context deadline reaches the server as grpc-timeout.
+		if _, ok := sctx.Deadline(); !ok {
+			t.Errorf("call %d: no deadline on server", i)
+		}
+		md, _ := metadata.FromIncomingContext(sctx)
+		if got := md.Get("x-server-timeout"); !reflect.DeepEqual(got, want) {
+			t.Errorf("call %d: x-server-timeout: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestConfigureDuringCalls(t *testing.T) {
+	fake := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("hi"))}}
+	model := newFakeClient(t, fake).GenerativeModel("m")
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := model.GenerateContent(ctx, Text("hi")); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		i := i
+		model.Configure(func(m *GenerativeModel) {
+			m.Temperature = Ptr(float32(i) / 20)
+			m.MaxOutputTokens = int32(100 + i)
+			m.StopSequences = []string{fmt.Sprint(i)}
+			m.SafetySettings = []*SafetySetting{{Category: HarmCategoryHarassment, Threshold: HarmBlockOnlyHigh}}
+			m.ServerTimeout = time.Duration(i+1) * time.Second
+		})
+	}
+	wg.Wait()
+
+	// Each request holds one consistent snapshot of the config, including
+	// the settings sent as headers.
+	reqs, ctxs := fake.calls()
+	for j, req := range reqs {
+		gc := req.GenerationConfig
+		md, _ := metadata.FromIncomingContext(ctxs[j])
+		timeout := md.Get("x-server-timeout")
+		if n := gc.GetMaxOutputTokens(); n != defaultMaxOutputTokens {
+			i := n - 100
+			if gc.GetTemperature() != float32(i)/20 || !reflect.DeepEqual(gc.StopSequences, []string{fmt.Sprint(i)}) {
+				t.Errorf("inconsistent config: %v", gc)
+			}
+			if want := []string{fmt.Sprint(i + 1)}; !reflect.DeepEqual(timeout, want) {
+				t.Errorf("config %v: got x-server-timeout %q, want %q", gc, timeout, want)
+			}
+		} else if len(timeout) != 0 {
+			t.Errorf("default config: got x-server-timeout %q, want none", timeout)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	model := newFakeClient(t, &fakeServer{}).GenerativeModel("m")
+	model.Temperature = Ptr[float32](0.5)
+	model.StopSequences = []string{"stop"}
+	model.SafetySettings = []*SafetySetting{{Category: HarmCategoryHarassment, Threshold: HarmBlockOnlyHigh}}
+	model.Tools = []*Tool{{FunctionDeclarations: []*FunctionDeclaration{{
+		Name:       "f",
+		Parameters: &Schema{Type: TypeObject, Required: []string{"x"}},
+	}}}}
+	model.SystemInstruction = &Content{Parts: []Part{
+		Text("be brief"),
+		AnnotatedPart{Part: Text("and kind"), Metadata: map[string]any{"source": "style guide"}},
+	}}
+
+	clone := model.Clone()
+	if !reflect.DeepEqual(clone.SystemInstruction, model.SystemInstruction) {
+		t.Errorf("SystemInstruction: got %+v, want %+v", clone.SystemInstruction, model.SystemInstruction)
+	}
+	if clone.Name() != model.Name() || *clone.Temperature != 0.5 {
+		t.Fatalf("clone differs from original: %+v", clone)
+	}
+	*clone.Temperature = 0.9
+	clone.StopSequences[0] = "changed"
+	clone.SafetySettings[0].Threshold = HarmBlockLowAndAbove
+	clone.Tools[0].FunctionDeclarations[0].Parameters.Required[0] = "y"
+	clone.SystemInstruction.Parts[0] = Text("be verbose")
+	clone.SystemInstruction.Parts[1].(AnnotatedPart).Metadata["source"] = "changed"
+
+	if got := *model.Temperature; got != 0.5 {
+		t.Errorf("original Temperature: got %v, want 0.5", got)
+	}
+	if got := model.StopSequences[0]; got != "stop" {
+		t.Errorf("original StopSequences: got %q, want %q", got, "stop")
+	}
+	if got := model.SafetySettings[0].Threshold; got != HarmBlockOnlyHigh {
+		t.Errorf("original Threshold: got %v, want %v", got, HarmBlockOnlyHigh)
+	}
+	if got := model.Tools[0].FunctionDeclarations[0].Parameters.Required[0]; got != "x" {
+		t.Errorf("original Required: got %q, want %q", got, "x")
+	}
+	if got := model.SystemInstruction.Parts[0]; got != Text("be brief") {
+		t.Errorf("original SystemInstruction: got %v, want %q", got, "be brief")
+	}
+	if got := model.SystemInstruction.Parts[1].(AnnotatedPart).Metadata["source"]; got != "style guide" {
+		t.Errorf("original Metadata: got %v, want %q", got, "style guide")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	fake := &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("hi"))},
+		errs:      []error{nil, status.Error(codes.Internal, "boom")},
+		header:    metadata.Pairs("x-goog-request-id", "req-123"),
+	}
+	model := newFakeClient(t, fake).GenerativeModel("m")
+	ctx := context.Background()
+	resp, err := model.GenerateContent(ctx, Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.RequestID, "req-123"; got != want {
+		t.Errorf("response: got %q, want %q", got, want)
+	}
+
+	_, err = model.GenerateContent(ctx, Text("hi"))
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("got %v, want Internal", err)
+	}
+	if got, want := RequestIDFromError(err), "req-123"; got != want {
+		t.Errorf("error: got %q, want %q", got, want)
+	}
+
+	iter := model.GenerateContentStream(ctx, Text("hi"))
+	if _, err := all(iter); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := iter.RequestID(), "req-123"; got != want {
+		t.Errorf("iterator: got %q, want %q", got, want)
+	}
+}
+
+func TestModelNotFound(t *testing.T) {
+	fake := &fakeServer{
+		errs: []error{
+			status.Error(codes.NotFound, "Publisher Model `projects/proj/locations/loc/publishers/google/models/gemini-prox` not found."),
+			status.Error(codes.NotFound, "something else"),
+		},
+	}
+	model := newFakeClient(t, fake).GenerativeModel("gemini-prox")
+	_, err := model.GenerateContent(context.Background(), Text("hi"))
+	var mnf *ModelNotFoundError
+	if !errors.As(err, &mnf) {
+		t.Fatalf("got %v, want a ModelNotFoundError", err)
+	}
+	if got, want := mnf.Name, "projects/proj/locations/loc/publishers/google/models/gemini-prox"; got != want {
+		t.Errorf("got name %q, want %q", got, want)
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("got code %v, want NotFound", status.Code(err))
+	}
+	checkMatch(t, err.Error(), "gemini-prox", "available models")
+
+	// Other things that are not found are not about the model.
+	_, err = model.GenerateContent(context.Background(), Text("hi"))
+	if errors.As(err, &mnf) || status.Code(err) != codes.NotFound {
+		t.Errorf("got %v, want a plain NotFound error", err)
+	}
+}
+
+func TestPruneEmptyText(t *testing.T) {
+	model := (&Client{}).GenerativeModel("m")
+	img := ImageData("png", []byte("\x89PNG"))
+	contents := []*Content{
+		{Role: roleUser, Parts: []Part{Text(""), Text("Look:"), Text(""), img, Text(""), Text("What is it?")}},
+		{Role: roleModel, Parts: []Part{Text("")}},
+	}
+	texts := func(c *pb.Content) []string {
+		var ts []string
+		for _, p := range c.Parts {
+			if t, ok := p.Data.(*pb.Part_Text); ok {
+				ts = append(ts, t.Text)
+			} else {
+				ts = append(ts, "<blob>")
+			}
+		}
+		return ts
+	}
+
+	req, err := model.newGenerateContentRequest(contents...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := texts(req.Contents[0]), []string{"Look:", "<blob>", "What is it?"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if n := len(req.Contents[1].Parts); n != 0 {
+		t.Errorf("got %d parts in second content, want 0", n)
+	}
+	if n := len(contents[0].Parts); n != 6 {
+		t.Errorf("contents modified: got %d parts, want 6", n)
+	}
+
+	model.KeepEmptyText = true
+	req, err = model.newGenerateContentRequest(contents...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := texts(req.Contents[0]), []string{"", "Look:", "", "<blob>", "", "What is it?"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("KeepEmptyText: got %q, want %q", got, want)
+	}
+}
+
+func TestSystemInstruction(t *testing.T) {
+	fake := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("Arr!"))}}
+	model := newFakeClient(t, fake).GenerativeModel("m")
+	model.SystemInstruction = &Content{Role: "whatever", Parts: []Part{Text("You are a pirate.")}}
+	ctx := context.Background()
+	if _, err := model.GenerateContent(ctx, Text("Hello.")); err != nil {
+		t.Fatal(err)
+	}
+	cs := model.StartChat()
+	for _, msg := range []string{"Hi.", "Where is the treasure?"} {
+		if _, err := cs.SendMessage(ctx, Text(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reqs, _ := fake.calls()
+	for i, want := range [][]string{
+		{"user: You are a pirate.|Hello."},
+		{"user: You are a pirate.|Hi."},
+		{"user: You are a pirate.|Hi.", "model: Arr!", "user: Where is the treasure?"},
+	} {
+		var got []string
+		for _, c := range reqs[i].Contents {
+			var texts []string
+			for _, p := range c.Parts {
+				texts = append(texts, p.GetText())
+			}
+			got = append(got, c.Role+": "+strings.Join(texts, "|"))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("call %d: got %q, want %q", i, got, want)
+		}
+	}
+	// The history does not hold the system instruction.
+	if got := len(cs.History[0].Parts); got != 1 {
+		t.Errorf("got %d parts in first turn of history, want 1", got)
+	}
+
+	// Its tokens are counted.
+	res, err := model.CountTokens(ctx, Text("Hello."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.TotalTokens, int32(5); got != want {
+		t.Errorf("got %d tokens, want %d", got, want)
+	}
+}
+
+func TestTrafficType(t *testing.T) {
+	fake := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("hi"))}}
+	model := newFakeClient(t, fake).GenerativeModel("m")
+	ctx := context.Background()
+	for _, tt := range []TrafficType{"", TrafficTypeDedicated, TrafficTypeShared} {
+		model.TrafficType = tt
+		if _, err := model.GenerateContent(ctx, Text("hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	model.TrafficType = TrafficTypeShared
+	if _, err := all(model.GenerateContentStream(ctx, Text("hi"))); err != nil {
+		t.Fatal(err)
+	}
+
+	_, contexts := fake.calls()
+	for i, want := range [][]string{nil, {"dedicated"}, {"shared"}, {"shared"}} {
+		md, _ := metadata.FromIncomingContext(contexts[i])
+		if got := md.Get("x-vertex-ai-llm-request-type"); !reflect.DeepEqual(got, want) {
+			t.Errorf("call %d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestGenerateContentWithUsage(t *testing.T) {
+	final := modelResponse(textPart(" world"))
+	final.UsageMetadata = &pb.GenerateContentResponse_UsageMetadata{
+		PromptTokenCount:     4,
+		CandidatesTokenCount: 2,
+		TotalTokenCount:      6,
+	}
+	first := modelResponse(textPart("hello"))
+	first.UsageMetadata = &pb.GenerateContentResponse_UsageMetadata{PromptTokenCount: 4, TotalTokenCount: 4}
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{first, modelResponse(), final},
+	})
+	resp, usage, err := client.GenerativeModel("m").GenerateContentWithUsage(context.Background(), Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := responseString(resp), "hello world"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	want := &UsageMetadata{PromptTokenCount: 4, CandidatesTokenCount: 2, TotalTokenCount: 6}
+	if !reflect.DeepEqual(usage, want) {
+		t.Errorf("got %+v, want %+v", usage, want)
+	}
+	if usage != resp.UsageMetadata {
+		t.Error("usage is not the response's UsageMetadata")
+	}
+}
+
+func TestHashRequest(t *testing.T) {
+	client := &Client{projectID: "p", location: "l"}
+	hash := func(m *GenerativeModel, parts ...Part) string {
+		t.Helper()
+		h, err := m.HashRequest(parts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	m1 := client.GenerativeModel("m")
+	m2 := client.GenerativeModel("m")
+	parts := []Part{Text("What is in this picture?"), ImageData("png", []byte{1, 2, 3})}
+	h := hash(m1, parts...)
+	if got := hash(m2, parts...); got != h {
+		t.Errorf("identical requests: got %s and %s, want equal", h, got)
+	}
+	if got := hash(m1, Text("What is in this picture?"), ImageData("png", []byte{1, 2, 4})); got == h {
+		t.Error("different data: got equal hashes")
+	}
+	m2.Temperature = Ptr[float32](0.5)
+	if got := hash(m2, parts...); got == h {
+		t.Error("different temperature: got equal hashes")
+	}
+	if got := hash(client.GenerativeModel("other"), parts...); got == h {
+		t.Error("different model: got equal hashes")
+	}
+}
+
+func TestDuplicateFunctionNames(t *testing.T) {
+	model := (&Client{}).GenerativeModel("m")
+	model.Tools = []*Tool{
+		{FunctionDeclarations: []*FunctionDeclaration{{Name: "get_weather"}, {Name: "get_time"}}},
+		{FunctionDeclarations: []*FunctionDeclaration{{Name: "get_weather"}}},
+	}
+	_, err := model.newGenerateContentRequest(newUserContent([]Part{Text("hi")}))
+	if err == nil || !strings.Contains(err.Error(), `"get_weather"`) {
+		t.Errorf("got %v, want error about get_weather", err)
+	}
+
+	model.Tools[1].FunctionDeclarations[0].Name = "get_location"
+	req, err := model.newGenerateContentRequest(newUserContent([]Part{Text("hi")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(req.Tools), 2; g != w {
+		t.Errorf("got %d tools, want %d", g, w)
+	}
+}
+
+// fakeServer is a fake PredictionService for tests.
+func TestFallbackModel(t *testing.T) {
+	srv := &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("from fallback"))},
+		errs:      []error{status.Error(codes.ResourceExhausted, "quota exceeded")},
+	}
+	client := newFakeClient(t, srv)
+	model := client.GenerativeModel("primary")
+	model.FallbackModel = "backup"
+	resp, err := model.GenerateContent(context.Background(), Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := responseString(resp), "from fallback"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	reqs, _ := srv.calls()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d calls, want 2", len(reqs))
+	}
+	for i, want := range []string{"primary", "backup"} {
+		if got := reqs[i].Model; !strings.HasSuffix(got, "/models/"+want) {
+			t.Errorf("call %d: got model %q, want %q", i, got, want)
+		}
+	}
+
+	// Without a fallback, the error is returned.
+	srv.mu.Lock()
+	srv.errs = []error{status.Error(codes.ResourceExhausted, "quota exceeded")}
+	srv.mu.Unlock()
+	if _, err := client.GenerativeModel("primary").GenerateContent(context.Background(), Text("hi")); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("no fallback: got %v, want ResourceExhausted", err)
+	}
+}
+
+func TestEmptyResponseRetries(t *testing.T) {
+	empty := []*pb.GenerateContentResponse{{}}
+	srv := &fakeServer{turns: [][]*pb.GenerateContentResponse{
+		empty,
+		{modelResponse(textPart(""))},
+		{modelResponse(textPart("finally"))},
+	}}
+	model := newFakeClient(t, srv).GenerativeModel("m")
+	model.EmptyResponseRetries = 2
+	ctx := context.Background()
+	resp, err := model.GenerateContent(ctx, Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := responseString(resp), "finally"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if reqs, _ := srv.calls(); len(reqs) != 3 {
+		t.Errorf("got %d calls, want 3", len(reqs))
+	}
+
+	// When the retries are used up, the empty response is returned.
+	srv.mu.Lock()
+	srv.requests = nil
+	srv.turns = [][]*pb.GenerateContentResponse{empty, empty, empty, {modelResponse(textPart("too late"))}}
+	srv.mu.Unlock()
+	resp, err = model.GenerateContent(ctx, Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Candidates) != 0 {
+		t.Errorf("got %d candidates, want none", len(resp.Candidates))
+	}
+	if reqs, _ := srv.calls(); len(reqs) != 3 {
+		t.Errorf("got %d calls, want 3", len(reqs))
+	}
+}
+
+func TestSoftBlockWarning(t *testing.T) {
+	feedback := func(p pb.SafetyRating_HarmProbability) *pb.GenerateContentResponse_PromptFeedback {
+		return &pb.GenerateContentResponse_PromptFeedback{
+			SafetyRatings: []*pb.SafetyRating{{
+				Category:    pb.HarmCategory_HARM_CATEGORY_HARASSMENT,
+				Probability: p,
+			}},
+		}
+	}
+	resp := modelResponse(textPart("ok"))
+	resp.PromptFeedback = feedback(pb.SafetyRating_LOW)
+	srv := &fakeServer{responses: []*pb.GenerateContentResponse{resp}}
+	model := newFakeClient(t, srv).GenerativeModel("m")
+	got, err := model.GenerateContent(context.Background(), Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := responseString(got), "ok"; g != w {
+		t.Errorf("got %q, want %q", g, w)
+	}
+	if got.SoftBlockWarning == nil {
+		t.Fatal("got nil SoftBlockWarning")
+	}
+	if rs := got.SoftBlockWarning.SafetyRatings; len(rs) != 1 || rs[0].Category != HarmCategoryHarassment || rs[0].Probability != HarmProbabilityLow {
+		t.Errorf("got ratings %+v", rs)
+	}
+
+	// Without a block reason, even a higher severity is not an error.
+	resp = modelResponse(textPart("ok"))
+	resp.PromptFeedback = feedback(pb.SafetyRating_MEDIUM)
+	srv.mu.Lock()
+	srv.responses = []*pb.GenerateContentResponse{resp}
+	srv.mu.Unlock()
+	got, err = model.GenerateContent(context.Background(), Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SoftBlockWarning == nil {
+		t.Error("medium: got nil SoftBlockWarning")
+	}
+
+	// A block reason is a hard block.
+	resp = modelResponse(textPart("ok"))
+	resp.PromptFeedback = feedback(pb.SafetyRating_MEDIUM)
+	resp.PromptFeedback.BlockReason = pb.GenerateContentResponse_PromptFeedback_SAFETY
+	srv.mu.Lock()
+	srv.responses = []*pb.GenerateContentResponse{resp}
+	srv.mu.Unlock()
+	_, err = model.GenerateContent(context.Background(), Text("hi"))
+	var berr *BlockedError
+	if !errors.As(err, &berr) || berr.PromptFeedback == nil {
+		t.Errorf("got %v, want BlockedError with PromptFeedback", err)
+	}
+}
+
+func TestPromptFeedbackWithoutBlockReason(t *testing.T) {
+	resp := modelResponse(textPart("ok"))
+	resp.PromptFeedback = &pb.GenerateContentResponse_PromptFeedback{
+		SafetyRatings: []*pb.SafetyRating{{
+			Category:    pb.HarmCategory_HARM_CATEGORY_HATE_SPEECH,
+			Probability: pb.SafetyRating_NEGLIGIBLE,
+		}},
+	}
+	srv := &fakeServer{responses: []*pb.GenerateContentResponse{resp}}
+	got, err := newFakeClient(t, srv).GenerativeModel("m").GenerateContent(context.Background(), Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PromptFeedback == nil || len(got.PromptFeedback.SafetyRatings) != 1 {
+		t.Errorf("got PromptFeedback %+v, want the ratings", got.PromptFeedback)
+	}
+	if got.SoftBlockWarning != nil {
+		t.Errorf("got SoftBlockWarning %+v, want nil", got.SoftBlockWarning)
+	}
+}
+
+func TestBlockedErrorMessage(t *testing.T) {
+	err := &BlockedError{
+		Candidate:      &Candidate{FinishReason: FinishReasonSafety},
+		PromptFeedback: &PromptFeedback{BlockReason: BlockedReasonSafety, BlockReasonMessage: "unsafe"},
+	}
+	msg := err.Error()
+	// The reasons are printed by name, not number.
+	if !strings.Contains(strings.ToUpper(msg), "SAFETY") || strings.ContainsAny(msg, "0123456789") {
+		t.Errorf("got %q, want the reasons by name", msg)
+	}
+}
+
+func TestReturnBlockedCandidates(t *testing.T) {
+	resp := &pb.GenerateContentResponse{Candidates: []*pb.Candidate{
+		{Index: 0, Content: &pb.Content{Role: roleModel, Parts: []*pb.Part{textPart("safe")}}, FinishReason: pb.Candidate_STOP},
+		{Index: 1, FinishReason: pb.Candidate_SAFETY},
+	}}
+	srv := &fakeServer{responses: []*pb.GenerateContentResponse{resp}}
+	model := newFakeClient(t, srv).GenerativeModel("m")
+	ctx := context.Background()
+
+	var berr *BlockedError
+	if _, err := model.GenerateContent(ctx, Text("hi")); !errors.As(err, &berr) {
+		t.Errorf("default: got %v, want BlockedError", err)
+	}
+
+	model.ReturnBlockedCandidates = true
+	got, err := model.GenerateContent(ctx, Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(got.Candidates); n != 2 {
+		t.Fatalf("got %d candidates, want 2", n)
+	}
+	if got.Candidates[0].FinishReason != FinishReasonStop || got.Candidates[1].FinishReason != FinishReasonSafety {
+		t.Errorf("got finish reasons %s, %s", got.Candidates[0].FinishReason, got.Candidates[1].FinishReason)
+	}
+}
+
+func TestUnsetTemperature(t *testing.T) {
+	model := &GenerativeModel{fullName: "m"}
+	gc := model.GenerationConfig.toProto()
+	if gc.Temperature != nil || gc.TopP != nil || gc.TopK != nil {
+		t.Errorf("unset: got %v, want no temperature, TopP or TopK", gc)
+	}
+	// Zero is sent when set explicitly.
+	model.Temperature = Ptr[float32](0)
+	if got := model.GenerationConfig.toProto().Temperature; got == nil || *got != 0 {
+		t.Errorf("got temperature %v, want 0", got)
+	}
+}
+
+func TestRequestDoesNotShareConfig(t *testing.T) {
+	model := &GenerativeModel{fullName: "m"}
+	model.Temperature = Ptr[float32](0.2)
+	model.StopSequences = []string{"END"}
+	req, err := model.newGenerateContentRequest(newUserContent([]Part{Text("hi")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.Configure(func(m *GenerativeModel) {
+		*m.Temperature = 0.9
+		m.StopSequences[0] = "STOP"
+	})
+	gc := req.GenerationConfig
+	if got := gc.GetTemperature(); got != 0.2 {
+		t.Errorf("got temperature %v, want 0.2", got)
+	}
+	if got, want := gc.StopSequences, []string{"END"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stop sequences %q, want %q", got, want)
+	}
+}
+
+func TestStopSequences(t *testing.T) {
+	model := &GenerativeModel{fullName: "m"}
+	model.StopSequences = []string{"\n\n", "END"}
+	req, err := model.newGenerateContentRequest(newUserContent([]Part{Text("hi")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.GenerationConfig.StopSequences, model.StopSequences; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	model.StopSequences = []string{}
+	if got := model.GenerationConfig.toProto().StopSequences; got != nil {
+		t.Errorf("empty: got %q, want nil", got)
+	}
+}
+
+func TestCandidateCount(t *testing.T) {
+	cand := func(index int32, text string) *pb.Candidate {
+		return &pb.Candidate{Index: index, Content: &pb.Content{Role: roleModel, Parts: []*pb.Part{textPart(text)}}}
+	}
+	// Chunks of two candidates, interleaved, with candidate 1 arriving first.
+	srv := &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			{Candidates: []*pb.Candidate{cand(1, "b1")}},
+			{Candidates: []*pb.Candidate{cand(0, "a1"), cand(1, "b2")}},
+			{Candidates: []*pb.Candidate{cand(0, "a2")}},
+		},
+	}
+	model := newFakeClient(t, srv).GenerativeModel("m")
+	iter := model.GenerateContentStream(context.Background(), Text("hi"))
+	if _, err := all(iter); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range iter.MergedResponse().Candidates {
+		got = append(got, fmt.Sprintf("%d:%s", c.Index, contentString(c.Content)))
+	}
+	if want := []string{"0:a1a2", "1:b1b2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The count is sent only when set.
+	model.CandidateCount = 2
+	if _, err := all(model.GenerateContentStream(context.Background(), Text("hi"))); err != nil {
+		t.Fatal(err)
+	}
+	reqs, _ := srv.calls()
+	if c := reqs[0].GenerationConfig.CandidateCount; c != nil {
+		t.Errorf("unset: got CandidateCount %d, want nil", *c)
+	}
+	if got := reqs[1].GenerationConfig.GetCandidateCount(); got != 2 {
+		t.Errorf("got CandidateCount %d, want 2", got)
+	}
+}
+
+func TestClientAccessors(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{})
+	if got, want := client.ProjectID(), "proj"; got != want {
+		t.Errorf("ProjectID: got %q, want %q", got, want)
+	}
+	if got, want := client.Location(), "loc"; got != want {
+		t.Errorf("Location: got %q, want %q", got, want)
+	}
+}
+
+func TestGenerateContentFromStrings(t *testing.T) {
+	srv := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("Blue."))}}
+	model := newFakeClient(t, srv).GenerativeModel("m")
+	ctx := context.Background()
+	resp, err := model.GenerateContentFromStrings(ctx, "Pick a color.", "Red.", "Another one.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := responseString(resp), "Blue."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	reqs, _ := srv.calls()
+	var got []string
+	for _, c := range reqs[0].Contents {
+		got = append(got, c.Role+": "+c.Parts[0].GetText())
+	}
+	want := []string{"user: Pick a color.", "model: Red.", "user: Another one."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, turns := range [][]string{nil, {"a", "b"}} {
+		if _, err := model.GenerateContentFromStrings(ctx, turns...); err == nil {
+			t.Errorf("%q: got nil, want error", turns)
+		}
+	}
+}
+
+func TestGenerateContentFromContents(t *testing.T) {
+	srv := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("It is sunny."))}}
+	model := newFakeClient(t, srv).GenerativeModel("m")
+	ctx := context.Background()
+	contents := []*Content{
+		{Role: roleUser, Parts: []Part{Text("What is the weather?")}},
+		{Role: roleModel, Parts: []Part{FunctionCall{Name: "weather", Args: map[string]any{"city": "Paris"}}}},
+		{Role: roleFunction, Parts: []Part{FunctionResponse{Name: "weather", Response: map[string]any{"sky": "clear"}}}},
+	}
+	resp, err := model.GenerateContentFromContents(ctx, contents...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := responseString(resp), "It is sunny."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	reqs, _ := srv.calls()
+	got := reqs[0].Contents
+	if len(got) != len(contents) {
+		t.Fatalf("got %d contents, want %d", len(got), len(contents))
+	}
+	for i, c := range contents {
+		if want := c.toProto(); !proto.Equal(got[i], want) {
+			t.Errorf("content %d: got %v, want %v", i, got[i], want)
+		}
+	}
+
+	if _, err := model.GenerateContentFromContents(ctx); err == nil {
+		t.Error("no contents: got nil, want error")
+	}
+}
+
+func TestSetRetry(t *testing.T) {
+	ctx := context.Background()
+	transient := func() []error {
+		return []error{
+			status.Error(codes.Unavailable, "try again"),
+			status.Error(codes.ResourceExhausted, "slow down"),
+		}
+	}
+	srv := &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("ok"))},
+		errs:      transient(),
+	}
+	client := newFakeClient(t, srv)
+	client.SetRetry(&RetryPolicy{MaxRetries: 3, Backoff: gax.Backoff{Initial: time.Millisecond}})
+	model := client.GenerativeModel("m")
+
+	resp, err := model.GenerateContent(ctx, Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := responseString(resp), "ok"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if reqs, _ := srv.calls(); len(reqs) != 3 {
+		t.Errorf("got %d calls, want 3", len(reqs))
+	}
+
+	srv.mu.Lock()
+	srv.errs = transient()
+	srv.mu.Unlock()
+	if _, err := model.CountTokens(ctx, Text("one two")); err != nil {
+		t.Errorf("CountTokens: %v", err)
+	}
+
+	// Errors that are not transient are returned at once.
+	srv.mu.Lock()
+	srv.errs = []error{status.Error(codes.InvalidArgument, "bad")}
+	srv.requests = nil
+	srv.mu.Unlock()
+	if _, err := model.GenerateContent(ctx, Text("hi")); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got %v, want InvalidArgument", err)
+	}
+	if reqs, _ := srv.calls(); len(reqs) != 1 {
+		t.Errorf("got %d calls, want 1", len(reqs))
+	}
+
+	// A stream that fails after a response is not retried.
+	srv.mu.Lock()
+	srv.midStreamErr = status.Error(codes.Unavailable, "dropped")
+	srv.requests = nil
+	srv.mu.Unlock()
+	iter := model.GenerateContentStream(ctx, Text("hi"))
+	if _, err := all(iter); status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v, want Unavailable", err)
+	}
+	if reqs, _ := srv.calls(); len(reqs) != 1 {
+		t.Errorf("got %d calls, want 1", len(reqs))
+	}
+	if got, want := responseString(iter.MergedResponse()), "ok"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCallOptionsTimeout(t *testing.T) {
+	ctx := context.Background()
+	opts := CallOptions{Timeout: time.Minute}
+	srv := &fakeServer{wait: true}
+	client := newFakeClient(t, srv)
+	fc := &fakeClock{now: time.Now()}
+	client.clk = fc
+	model := client.GenerativeModel("m")
+
+	go func() {
+		fc.awaitTimers(1)
+		fc.Advance(opts.Timeout)
+	}()
+	if _, err := model.GenerateContentWithOptions(ctx, opts, Text("hi")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GenerateContent: got %v, want DeadlineExceeded", err)
+	}
+	iter := model.GenerateContentStreamWithOptions(ctx, opts, Text("hi"))
+	go fc.Advance(opts.Timeout)
+	if _, err := iter.Next(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GenerateContentStream: got %v, want DeadlineExceeded", err)
+	}
+
+	// The service giving up first is reported the same way.
+	srv2 := &fakeServer{errs: []error{status.Error(codes.DeadlineExceeded, "server timeout")}}
+	client2 := newFakeClient(t, srv2)
+	client2.clk = &fakeClock{now: time.Now()}
+	if _, err := client2.GenerativeModel("m").GenerateContentWithOptions(ctx, opts, Text("hi")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("server timeout: got %v, want DeadlineExceeded", err)
+	}
+
+	// Once a response arrives, the stream outlives the timeout.
+	srv3 := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("ok"))}, wait: true}
+	client3 := newFakeClient(t, srv3)
+	fc3 := &fakeClock{now: time.Now()}
+	client3.clk = fc3
+	iter = client3.GenerativeModel("m").GenerateContentStreamWithOptions(ctx, opts, Text("hi"))
+	if _, err := iter.Next(); err != nil {
+		t.Fatal(err)
+	}
+	fc3.Advance(2 * opts.Timeout)
+	_, ctxs := srv3.calls()
+	if err := ctxs[0].Err(); err != nil {
+		t.Errorf("stream ended after the first response: %v", err)
+	}
+	iter.cancel()
+}
+
+func TestClientOptions(t *testing.T) {
+	user := []option.ClientOption{
+		option.WithEndpoint("localhost:1234"),
+		option.WithCredentialsJSON([]byte(`{"type": "service_account"}`)),
+		option.WithUserAgent("ua"),
+	}
+	got := clientOptions("us-central1", user)
+	want := append([]option.ClientOption{option.WithEndpoint("us-central1-aiplatform.googleapis.com:443")}, user...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The credentials reach the underlying client, which rejects them.
+	if _, err := NewClient(context.Background(), "proj", "loc", option.WithCredentialsJSON([]byte("not JSON"))); err == nil {
+		t.Error("bad credentials: got nil, want error")
+	}
+}
+
+func TestBufconnDialer(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	gsrv := grpc.NewServer()
+	pb.RegisterPredictionServiceServer(gsrv, &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("in process"))},
+	})
+	go gsrv.Serve(lis)
+	defer gsrv.Stop()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, "proj", "loc",
+		option.WithEndpoint("bufnet"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		})),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	resp, err := client.GenerativeModel("m").GenerateContent(ctx, Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := responseString(resp), "in process"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEndpointOverride(t *testing.T) {
+	ctx := context.Background()
+	dialed := make(chan string, 1)
+	client, err := NewClient(ctx, "proj", "loc",
+		option.WithEndpoint("localhost:1234"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			select {
+			case dialed <- addr:
+			default:
+			}
+			return nil, errors.New("not dialing")
+		})),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	client.GenerativeModel("m").GenerateContent(ctx, Text("hi"))
+	select {
+	case got := <-dialed:
+		if want := "localhost:1234"; got != want {
+			t.Errorf("dialed %q, want %q", got, want)
+		}
+	default:
+		t.Error("no address was dialed")
+	}
+}
+
+type fakeServer struct {
+	pb.UnimplementedPredictionServiceServer
+
+	// responses are streamed by StreamGenerateContent.
+	responses []*pb.GenerateContentResponse
+	// turns, if non-empty, holds the responses of successive calls. Each
+	// call takes the first element in place of responses, until they are
+	// used up.
+	turns [][]*pb.GenerateContentResponse
+	// delay is how long StreamGenerateContent waits before sending responses.
+	delay time.Duration
+	// midStreamErr, if non-nil, is returned by StreamGenerateContent after
+	// sending responses.
+	midStreamErr error
+	// If wait is true, StreamGenerateContent does not end the stream after
+	// sending responses, but waits for the call to be canceled.
+	wait bool
+	// errs holds errors that StreamGenerateContent returns, in order, instead
+	// of sending responses, until they are used up. A nil error lets the
+	// call proceed.
+	errs []error
+	// header, if non-nil, is sent as the header of each call.
+	header metadata.MD
+	// requests holds the requests received by StreamGenerateContent.
+	requests []*pb.GenerateContentRequest
+	// contexts holds the contexts of the calls to StreamGenerateContent.
+	contexts []context.Context
+
+	mu sync.Mutex // guards responses, turns, errs, requests and contexts
+}
+
+// calls returns the requests and contexts of the calls to StreamGenerateContent.
+func (s *fakeServer) calls() ([]*pb.GenerateContentRequest, []context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.contexts
+}
+
+func (s *fakeServer) StreamGenerateContent(req *pb.GenerateContentRequest, stream pb.PredictionService_StreamGenerateContentServer) error {
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.contexts = append(s.contexts, stream.Context())
+	var err error
+	if len(s.errs) > 0 {
+		err, s.errs = s.errs[0], s.errs[1:]
+	}
+	responses := s.responses
+	if err == nil && len(s.turns) > 0 {
+		responses, s.turns = s.turns[0], s.turns[1:]
+	}
+	s.mu.Unlock()
+	if s.header != nil {
+		if err := stream.SetHeader(s.header); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if s.delay > 0 {
+		time.Sleep(s.delay)
+	}
+	for _, r := range responses {
+		if err := stream.Send(r); err != nil {
+			return err
+		}
+	}
+	if s.midStreamErr != nil {
+		return s.midStreamErr
+	}
+	if s.wait {
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	}
+	return nil
+}
+
+// CountTokens counts one token per word of text. Like StreamGenerateContent,
+// it returns the errors in errs first.
+func (s *fakeServer) CountTokens(ctx context.Context, req *pb.CountTokensRequest) (*pb.CountTokensResponse, error) {
+	s.mu.Lock()
+	var err error
+	if len(s.errs) > 0 {
+		err, s.errs = s.errs[0], s.errs[1:]
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var n int32
+	for _, c := range req.Contents {
+		for _, p := range c.Parts {
+			n += int32(len(strings.Fields(p.GetText())))
+		}
+	}
+	return &pb.CountTokensResponse{TotalTokens: n}, nil
+}
+
+// newFakeClient returns a Client that talks to srv.
+func newFakeClient(t *testing.T, srv pb.PredictionServiceServer) *Client {
+	t.Helper()
+	ts, err := testutil.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb.RegisterPredictionServiceServer(ts.Gsrv, srv)
+	ts.Start()
+	t.Cleanup(ts.Close)
+	client, err := NewClient(context.Background(), "proj", "loc",
+		option.WithEndpoint(ts.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func modelResponse(parts ...*pb.Part) *pb.GenerateContentResponse {
+	return &pb.GenerateContentResponse{
+		Candidates: []*pb.Candidate{{Content: &pb.Content{Role: roleModel, Parts: parts}}},
+	}
+}
+
+func textPart(s string) *pb.Part {
+	return &pb.Part{Data: &pb.Part_Text{Text: s}}
+}
+
 func checkMatch(t *testing.T, got string, wants ...string) {
 	t.Helper()
 	for _, want := range wants {
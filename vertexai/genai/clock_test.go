@@ -0,0 +1,216 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClock is a clock whose time only moves when it sleeps or is advanced.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	when    time.Time
+	f       func()
+	stopped bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep records d and advances the time by it, without waiting.
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	c.sleeps = append(c.sleeps, d)
+	c.mu.Unlock()
+	c.Advance(d)
+	return ctx.Err()
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		stopped := !t.stopped
+		t.stopped = true
+		return stopped
+	}
+}
+
+// WithTimeout returns a context that is done when the fake time reaches d
+// from now, or when ctx is done. Its Err is then context.DeadlineExceeded or
+// the error of ctx.
+func (c *fakeClock) WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	tctx := &timeoutContext{Context: ctx, done: make(chan struct{})}
+	stop := c.AfterFunc(d, func() { tctx.finish(context.DeadlineExceeded) })
+	go func() {
+		select {
+		case <-ctx.Done():
+			tctx.finish(ctx.Err())
+		case <-tctx.done:
+		}
+	}()
+	return tctx, func() {
+		stop()
+		tctx.finish(context.Canceled)
+	}
+}
+
+// A timeoutContext is the context returned by fakeClock.WithTimeout.
+type timeoutContext struct {
+	context.Context
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *timeoutContext) Done() <-chan struct{} { return c.done }
+
+func (c *timeoutContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// finish ends the context with err, unless it has already ended.
+func (c *timeoutContext) finish(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
+
+// awaitTimers waits until n timers have been started, whether or not they
+// have expired or been stopped.
+func (c *fakeClock) awaitTimers(n int) {
+	for {
+		c.mu.Lock()
+		started := len(c.timers)
+		c.mu.Unlock()
+		if started >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Advance moves the time forward by d, calling the functions of the timers
+// that expire.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []func()
+	for _, t := range c.timers {
+		if !t.stopped && !t.when.After(c.now) {
+			t.stopped = true
+			due = append(due, t.f)
+		}
+	}
+	c.mu.Unlock()
+	for _, f := range due {
+		go f()
+	}
+}
+
+func TestRetryWithFakeClock(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "try again")
+	srv := &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("ok"))},
+		errs:      []error{unavailable, unavailable, unavailable},
+	}
+	client := newFakeClient(t, srv)
+	fc := &fakeClock{now: time.Now()}
+	client.clk = fc
+	// Pauses this long would make the test take minutes with a real clock.
+	client.SetRetry(&RetryPolicy{
+		MaxRetries: 3,
+		Backoff:    gax.Backoff{Initial: time.Minute, Max: 10 * time.Minute, Multiplier: 2},
+	})
+
+	start := time.Now()
+	if _, err := client.GenerativeModel("m").GenerateContent(context.Background(), Text("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("took %s of real time", d)
+	}
+	if n := len(fc.sleeps); n != 3 {
+		t.Fatalf("got %d pauses, want 3", n)
+	}
+	// The pauses are randomized, but bounded by the exponential backoff.
+	for i, limit := 0, time.Minute; i < 3; i, limit = i+1, 2*limit {
+		if d := fc.sleeps[i]; d <= 0 || d > limit {
+			t.Errorf("pause %d: got %s, want in (0, %s]", i, d, limit)
+		}
+	}
+
+	// A retry is not started if the deadline would pass during the pause.
+	// Move the fake time ahead, so the deadline is far off in real time.
+	fc.Advance(time.Hour)
+	srv.mu.Lock()
+	srv.errs = []error{unavailable}
+	srv.mu.Unlock()
+	ctx, cancel := context.WithDeadline(context.Background(), fc.Now().Add(time.Millisecond))
+	defer cancel()
+	if _, err := client.GenerativeModel("m").CountTokens(ctx, Text("hi")); status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v, want Unavailable", err)
+	}
+}
+
+func TestTimeoutWithFakeClock(t *testing.T) {
+	srv := &fakeServer{wait: true}
+	client := newFakeClient(t, srv)
+	fc := &fakeClock{}
+	client.clk = fc
+	model := client.GenerativeModel("m")
+	opts := CallOptions{Timeout: time.Hour}
+	iter := model.GenerateContentStreamWithOptions(context.Background(), opts, Text("hi"))
+	go fc.Advance(time.Hour)
+	if _, err := iter.Next(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("stream: got %v, want DeadlineExceeded", err)
+	}
+
+	go func() {
+		fc.awaitTimers(2)
+		fc.Advance(time.Hour)
+	}()
+	if _, err := model.GenerateContentWithOptions(context.Background(), opts, Text("hi")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unary: got %v, want DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// A ModelConfig holds settings of a GenerativeModel that can be stored and
+// shared, so that the models of different programs are configured alike.
+type ModelConfig struct {
+	GenerationConfig *GenerationConfig
+	SafetySettings   []*SafetySetting
+	Tools            []*Tool
+}
+
+// ParseModelConfig parses a ModelConfig from JSON in the form of the body of a
+// generateContent request to the Vertex AI REST API, restricted to the
+// fields "generationConfig", "safetySettings" and "tools". For example:
+//
+//	{
+//	  "generationConfig": {"temperature": 0.2, "maxOutputTokens": 1024},
+//	  "safetySettings": [
+//	    {"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_LOW_AND_ABOVE"}
+//	  ]
+//	}
+func ParseModelConfig(data []byte) (*ModelConfig, error) {
+	var req pb.GenerateContentRequest
+	if err := protojson.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("genai: parsing model config: %w", err)
+	}
+	if req.Model != "" || len(req.Contents) > 0 {
+		return nil, errors.New("genai: parsing model config: only generationConfig, safetySettings and tools may be set")
+	}
+	return &ModelConfig{
+		GenerationConfig: (GenerationConfig{}).fromProto(req.GenerationConfig),
+		SafetySettings:   mapSlice(req.SafetySettings, (SafetySetting{}).fromProto),
+		Tools:            mapSlice(req.Tools, (Tool{}).fromProto),
+	}, nil
+}
+
+// LoadModelConfig reads a ModelConfig from the Cloud Storage object named by
+// uri, which must have the form "gs://bucket/object". The contents are parsed
+// with ParseModelConfig.
+func LoadModelConfig(ctx context.Context, client *storage.Client, uri string) (*ModelConfig, error) {
+	bucket, object, err := parseGCSURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	r, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("genai: reading model config %s: %w", uri, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("genai: reading model config %s: %w", uri, err)
+	}
+	return ParseModelConfig(data)
+}
+
+// ApplyConfig sets the fields of m from the non-nil fields of c, replacing
+// the current values. It is safe to call while calls are being made with m;
+// see Configure.
+func (m *GenerativeModel) ApplyConfig(c *ModelConfig) {
+	m.Configure(func(m *GenerativeModel) {
+		if c.GenerationConfig != nil {
+			m.GenerationConfig = *c.GenerationConfig
+		}
+		if c.SafetySettings != nil {
+			m.SafetySettings = c.SafetySettings
+		}
+		if c.Tools != nil {
+			m.Tools = c.Tools
+		}
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+const testModelConfig = `{
+  "generationConfig": {"temperature": 0.2, "maxOutputTokens": 1024},
+  "safetySettings": [
+    {"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_LOW_AND_ABOVE"}
+  ]
+}`
+
+func TestLoadModelConfig(t *testing.T) {
+	// A fake of Cloud Storage that serves one object.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/configs/chat.json") {
+			http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(testModelConfig))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	sc, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	config, err := LoadModelConfig(ctx, sc, "gs://configs/chat.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := &GenerativeModel{}
+	model.TopK = Ptr[float32](40)
+	model.Tools = []*Tool{{FunctionDeclarations: []*FunctionDeclaration{{Name: "f"}}}}
+	model.ApplyConfig(config)
+
+	wantConfig := GenerationConfig{Temperature: Ptr[float32](0.2), MaxOutputTokens: 1024}
+	if !reflect.DeepEqual(model.GenerationConfig, wantConfig) {
+		t.Errorf("GenerationConfig: got %+v, want %+v", model.GenerationConfig, wantConfig)
+	}
+	wantSafety := []*SafetySetting{{Category: HarmCategoryHarassment, Threshold: HarmBlockLowAndAbove}}
+	if !reflect.DeepEqual(model.SafetySettings, wantSafety) {
+		t.Errorf("SafetySettings: got %+v, want %+v", model.SafetySettings, wantSafety)
+	}
+	// Fields absent from the config are kept.
+	if len(model.Tools) != 1 {
+		t.Errorf("Tools: got %+v, want the model's", model.Tools)
+	}
+
+	if _, err := LoadModelConfig(ctx, sc, "gs://configs/missing.json"); err == nil {
+		t.Error("missing object: got nil, want error")
+	}
+	for _, bad := range []string{`{"temperature": 1}`, `{"contents": [{"role": "user"}]}`} {
+		if _, err := ParseModelConfig([]byte(bad)); err == nil {
+			t.Errorf("%s: got nil, want error", bad)
+		}
+	}
+}
@@ -15,17 +15,28 @@
 package genai
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
 	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const (
-	roleUser  = "user"
-	roleModel = "model"
+	roleUser     = "user"
+	roleModel    = "model"
+	roleFunction = "function"
 )
 
-// A Part is either a Text, a Blob, or a FileData.
+// A Part is either a Text, a Blob, a FileData, a FunctionCall, a FunctionResponse
+// or an AnnotatedPart.
 type Part interface {
 	toPart() *pb.Part
 }
@@ -51,6 +62,16 @@ func partFromProto(p *pb.Part) Part {
 			MIMEType: d.FileData.MimeType,
 			FileURI:  d.FileData.FileUri,
 		}
+	case *pb.Part_FunctionCall:
+		return FunctionCall{
+			Name: d.FunctionCall.Name,
+			Args: d.FunctionCall.Args.AsMap(),
+		}
+	case *pb.Part_FunctionResponse:
+		return FunctionResponse{
+			Name:     d.FunctionResponse.Name,
+			Response: d.FunctionResponse.Response.AsMap(),
+		}
 	default:
 		panic(fmt.Errorf("unknown Part.Data type %T", p.Data))
 	}
@@ -81,6 +102,188 @@ func (f FileData) toPart() *pb.Part {
 	}
 }
 
+// FunctionCall is a request from the model to call a function
+// with the given arguments.
+type FunctionCall struct {
+	// Name is the name of the function to call.
+	Name string
+	// Args are the arguments to the function, as a JSON object.
+	Args map[string]any
+}
+
+func (f FunctionCall) toPart() *pb.Part {
+	// An error is reported by checkParts before the part is converted.
+	args, _ := toStruct(f.Args)
+	return &pb.Part{
+		Data: &pb.Part_FunctionCall{
+			FunctionCall: &pb.FunctionCall{
+				Name: f.Name,
+				Args: args,
+			},
+		},
+	}
+}
+
+// FunctionResponse is the result of calling a function, sent back to the
+// model so that it can use the output.
+type FunctionResponse struct {
+	// Name is the name of the function that was called.
+	Name string
+	// Response is the output of the function, as a JSON object.
+	Response map[string]any
+}
+
+// NewFunctionResponse returns a FunctionResponse for the function name whose
+// Response is result encoded as JSON. If result does not encode as a JSON
+// object, it is stored under the key "content".
+func NewFunctionResponse(name string, result any) (FunctionResponse, error) {
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		return FunctionResponse{}, fmt.Errorf("genai: FunctionResponse %q: %w", name, err)
+	}
+	var v any
+	if err := json.Unmarshal(bytes, &v); err != nil {
+		return FunctionResponse{}, fmt.Errorf("genai: FunctionResponse %q: %w", name, err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		m = map[string]any{"content": v}
+	}
+	return FunctionResponse{Name: name, Response: m}, nil
+}
+
+func (f FunctionResponse) toPart() *pb.Part {
+	// An error is reported by checkParts before the part is converted.
+	resp, _ := toStruct(f.Response)
+	return &pb.Part{
+		Data: &pb.Part_FunctionResponse{
+			FunctionResponse: &pb.FunctionResponse{
+				Name:     f.Name,
+				Response: resp,
+			},
+		},
+	}
+}
+
+// cloneContent returns a deep copy of c, or nil if c is nil.
+func cloneContent(c *Content) *Content {
+	if c == nil {
+		return nil
+	}
+	return &Content{Role: c.Role, Parts: mapSlice(c.Parts, clonePart)}
+}
+
+// clonePart returns a deep copy of p.
+func clonePart(p Part) Part {
+	switch p := p.(type) {
+	case Blob:
+		p.Data = append([]byte(nil), p.Data...)
+		return p
+	case FunctionCall:
+		p.Args = cloneJSONObject(p.Args)
+		return p
+	case FunctionResponse:
+		p.Response = cloneJSONObject(p.Response)
+		return p
+	case AnnotatedPart:
+		return AnnotatedPart{Part: clonePart(p.Part), Metadata: cloneJSONObject(p.Metadata)}
+	default:
+		// Text and FileData hold no references.
+		return p
+	}
+}
+
+// cloneJSONObject returns a copy of m that shares no maps or slices of type
+// map[string]any or []any with it. Other values are copied shallowly.
+func cloneJSONObject(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = cloneJSONValue(v)
+	}
+	return out
+}
+
+func cloneJSONValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return cloneJSONObject(v)
+	case []any:
+		return mapSlice(v, cloneJSONValue)
+	default:
+		return v
+	}
+}
+
+// toStruct converts m, a JSON object, to a Struct. Values that structpb does
+// not accept directly, like typed slices and structs, are converted through
+// their JSON encoding.
+func toStruct(m map[string]any) (*structpb.Struct, error) {
+	if s, err := structpb.NewStruct(m); err == nil {
+		return s, nil
+	}
+	bytes, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	s := &structpb.Struct{}
+	if err := protojson.Unmarshal(bytes, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// checkParts returns an error if a part of contents cannot be sent to the
+// model because it holds a map that cannot be encoded as a JSON object.
+// The toPart methods have no way to report the error, so requests must be
+// checked before they are converted.
+func checkParts(contents ...*Content) error {
+	for _, c := range contents {
+		if c == nil {
+			continue
+		}
+		for _, p := range c.Parts {
+			if err := checkPart(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkPart(p Part) error {
+	switch p := p.(type) {
+	case AnnotatedPart:
+		return checkPart(p.Part)
+	case FunctionCall:
+		if _, err := toStruct(p.Args); err != nil {
+			return fmt.Errorf("genai: FunctionCall %q: %w", p.Name, err)
+		}
+	case FunctionResponse:
+		if _, err := toStruct(p.Response); err != nil {
+			return fmt.Errorf("genai: FunctionResponse %q: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// AnnotatedPart is a Part with metadata for the caller's own use, such as the
+// ID of the document it came from. The metadata is never sent to the model:
+// the part is sent as if it were Part alone. It is kept by local operations
+// on contents, like [MergeHistories] and merging streamed responses.
+//
+// Parts in responses from the model are never AnnotatedParts.
+type AnnotatedPart struct {
+	Part     Part
+	Metadata map[string]any
+}
+
+func (a AnnotatedPart) toPart() *pb.Part {
+	return partToProto(a.Part)
+}
+
 // ImageData is a convenience function for creating an image
 // Blob for input to a model.
 // The format should be the second part of the MIME type, after "image/".
@@ -91,3 +294,77 @@ func ImageData(format string, data []byte) Blob {
 		Data:     data,
 	}
 }
+
+// AudioData is a convenience function for creating an audio
+// Blob for input to a model.
+// The format should be the second part of the MIME type, after "audio/".
+// For example, for an MP3 file, pass "mp3".
+func AudioData(format string, data []byte) Blob {
+	return Blob{
+		MIMEType: "audio/" + format,
+		Data:     data,
+	}
+}
+
+// VideoData is a convenience function for creating a video
+// Blob for input to a model.
+// The format should be the second part of the MIME type, after "video/".
+// For example, for an MP4 file, pass "mp4".
+func VideoData(format string, data []byte) Blob {
+	return Blob{
+		MIMEType: "video/" + format,
+		Data:     data,
+	}
+}
+
+// imageFormats maps file extensions to image formats for ImageDataFromFile.
+var imageFormats = map[string]string{
+	".png":  "png",
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".webp": "webp",
+	".gif":  "gif",
+}
+
+// ImageDataFromFile returns an image Blob holding the contents of the file at
+// path. The format is taken from the file's extension, or if it is not one of
+// .png, .jpg, .jpeg, .webp or .gif, detected from the contents.
+func ImageDataFromFile(path string) (Blob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Blob{}, fmt.Errorf("genai: %w", err)
+	}
+	if format, ok := imageFormats[strings.ToLower(filepath.Ext(path))]; ok {
+		return ImageData(format, data), nil
+	}
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return Blob{}, fmt.Errorf("genai: %s is not a recognized image (detected %s)", path, mimeType)
+	}
+	return Blob{MIMEType: mimeType, Data: data}, nil
+}
+
+// BlobFromBase64 returns a Blob holding the data encoded in b64, which must
+// be in standard base64 encoding, with the given MIME type.
+func BlobFromBase64(mimeType, b64 string) (Blob, error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return Blob{}, fmt.Errorf("genai: decoding base64 blob data: %w", err)
+	}
+	return Blob{MIMEType: mimeType, Data: data}, nil
+}
+
+// AudioParams parses the MIME type of an audio Blob, like
+// "audio/L16;rate=24000", returning the codec, which is the subtype as
+// written ("L16"), and the parameters, with lowercase keys ({"rate": "24000"}).
+// It returns "" and nil if b is not audio or its MIME type is malformed.
+func (b Blob) AudioParams() (codec string, params map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(b.MIMEType)
+	if err != nil || !strings.HasPrefix(mediaType, "audio/") {
+		return "", nil
+	}
+	// ParseMediaType lowercases the type, so take the codec from the original.
+	typ, _, _ := strings.Cut(b.MIMEType, ";")
+	_, codec, _ = strings.Cut(strings.TrimSpace(typ), "/")
+	return codec, params
+}
@@ -0,0 +1,216 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestBlobFromBase64(t *testing.T) {
+	got, err := BlobFromBase64("image/png", "iVBORw0KGgo=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Blob{MIMEType: "image/png", Data: []byte("\x89PNG\r\n\x1a\n")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"not base64!", "iVBORw0KGgo", "iVBO\x00RW0KGgo="} {
+		if _, err := BlobFromBase64("image/png", bad); err == nil {
+			t.Errorf("%q: got nil, want error", bad)
+		}
+	}
+}
+
+func TestAnnotatedPart(t *testing.T) {
+	meta := map[string]any{"doc": "d-17"}
+	part := AnnotatedPart{Part: Text("The sky is blue."), Metadata: meta}
+
+	// The metadata is not sent.
+	if got, want := partToProto(part), partToProto(Text("The sky is blue.")); !proto.Equal(got, want) {
+		t.Errorf("proto: got %v, want %v", got, want)
+	}
+
+	// The metadata survives local processing.
+	cs := &ChatSession{History: []*Content{{Role: roleUser, Parts: []Part{Text("Summarize:"), part}}}}
+	merged := MergeHistories(cs, cs)
+	if n := len(merged); n != 1 {
+		t.Fatalf("got %d contents, want 1", n)
+	}
+	parts := mergeTexts(merged[0].Parts)
+	want := []Part{Text("Summarize:"), part, Text("Summarize:"), part}
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("got %+v, want %+v", parts, want)
+	}
+}
+
+func TestAudioParams(t *testing.T) {
+	for _, test := range []struct {
+		mimeType   string
+		wantCodec  string
+		wantParams map[string]string
+	}{
+		{"audio/L16;rate=24000", "L16", map[string]string{"rate": "24000"}},
+		{"audio/L16; Rate=16000; channels=2", "L16", map[string]string{"rate": "16000", "channels": "2"}},
+		{"audio/mpeg", "mpeg", map[string]string{}},
+		{"image/png", "", nil},
+		{"audio/", "", nil},
+	} {
+		codec, params := Blob{MIMEType: test.mimeType}.AudioParams()
+		if codec != test.wantCodec || !reflect.DeepEqual(params, test.wantParams) {
+			t.Errorf("%q: got (%q, %v), want (%q, %v)", test.mimeType, codec, params, test.wantCodec, test.wantParams)
+		}
+	}
+
+	// The full MIME type of audio in a response is kept.
+	p := partFromProto(&pb.Part{Data: &pb.Part_InlineData{InlineData: &pb.Blob{MimeType: "audio/L16;rate=24000"}}})
+	if got := p.(Blob).MIMEType; got != "audio/L16;rate=24000" {
+		t.Errorf("from proto: got %q", got)
+	}
+}
+
+func TestAudioVideoData(t *testing.T) {
+	data := []byte("data")
+	for _, test := range []struct {
+		blob Blob
+		want string
+	}{
+		{AudioData("mp3", data), "audio/mp3"},
+		{AudioData("wav", data), "audio/wav"},
+		{VideoData("mp4", data), "video/mp4"},
+	} {
+		want := &pb.Part{Data: &pb.Part_InlineData{InlineData: &pb.Blob{MimeType: test.want, Data: data}}}
+		if got := test.blob.toPart(); !proto.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestNewFunctionResponse(t *testing.T) {
+	type weather struct {
+		City  string   `json:"city"`
+		Temp  float64  `json:"temp"`
+		Notes []string `json:"notes,omitempty"`
+	}
+	fr, err := NewFunctionResponse("get_weather", weather{City: "Paris", Temp: 21.5, Notes: []string{"sunny"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FunctionResponse{
+		Name:     "get_weather",
+		Response: map[string]any{"city": "Paris", "temp": 21.5, "notes": []any{"sunny"}},
+	}
+	if !reflect.DeepEqual(fr, want) {
+		t.Errorf("got %+v, want %+v", fr, want)
+	}
+	if got := partFromProto(fr.toPart()); !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip: got %+v, want %+v", got, want)
+	}
+
+	fr, err = NewFunctionResponse("count", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fr.Response, map[string]any{"content": 3.0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := NewFunctionResponse("bad", make(chan int)); err == nil {
+		t.Error("got nil, want error")
+	}
+}
+
+func TestFunctionResponseValues(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+	}
+	fr := FunctionResponse{Name: "f", Response: map[string]any{
+		"items": []string{"a"},
+		"item":  item{Name: "b"},
+	}}
+	if err := checkParts(&Content{Parts: []Part{fr}}); err != nil {
+		t.Fatal(err)
+	}
+	want := FunctionResponse{Name: "f", Response: map[string]any{
+		"items": []any{"a"},
+		"item":  map[string]any{"name": "b"},
+	}}
+	if got := partFromProto(fr.toPart()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// A response that is not JSON is an error, not a panic.
+	bad := &Content{Parts: []Part{AnnotatedPart{Part: FunctionResponse{Name: "f", Response: map[string]any{"c": make(chan int)}}}}}
+	if err := checkParts(bad); err == nil {
+		t.Error("got nil, want error")
+	}
+	model := &GenerativeModel{}
+	if _, err := model.newGenerateContentRequest(bad); err == nil {
+		t.Error("newGenerateContentRequest: got nil, want error")
+	}
+}
+
+func TestImageDataFromFile(t *testing.T) {
+	const (
+		png  = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+		jpeg = "\xff\xd8\xff\xe0\x00\x10JFIF"
+		webp = "RIFF\x00\x00\x00\x00WEBPVP8 "
+		gif  = "GIF89a\x01\x00\x01\x00"
+	)
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name, data, want string
+	}{
+		{"a.png", png, "image/png"},
+		{"b.JPG", jpeg, "image/jpeg"},
+		{"c.jpeg", jpeg, "image/jpeg"},
+		{"d.webp", webp, "image/webp"},
+		{"e.gif", gif, "image/gif"},
+		// Unknown or missing extensions fall back to the contents.
+		{"f.img", png, "image/png"},
+		{"g", webp, "image/webp"},
+	} {
+		path := filepath.Join(dir, test.name)
+		if err := os.WriteFile(path, []byte(test.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ImageDataFromFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		want := Blob{MIMEType: test.want, Data: []byte(test.data)}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, want)
+		}
+	}
+
+	text := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(text, []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{text, filepath.Join(dir, "missing.png")} {
+		if _, err := ImageDataFromFile(path); err == nil {
+			t.Errorf("%s: got nil, want error", path)
+		}
+	}
+}
@@ -13,3 +13,22 @@
 // limitations under the License.
 
 package genai
+
+import "context"
+
+// CountTokensPerPart counts the tokens in each of parts separately, returning
+// the counts in the same order. The API does not break counts down by part,
+// so CountTokensPerPart makes one call to count the tokens of each part. The
+// model's SystemInstruction is not counted. The sum of the counts may differ
+// slightly from the count for all the parts together.
+func (m *GenerativeModel) CountTokensPerPart(ctx context.Context, parts ...Part) ([]int32, error) {
+	counts := make([]int32, len(parts))
+	for i, p := range parts {
+		res, err := m.countTokens(ctx, false, newUserContent([]Part{p}))
+		if err != nil {
+			return nil, err
+		}
+		counts[i] = res.TotalTokens
+	}
+	return counts, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCountTokensPerPart(t *testing.T) {
+	model := newFakeClient(t, &fakeServer{}).GenerativeModel("m")
+	got, err := model.CountTokensPerPart(context.Background(),
+		Text("Summarize"),
+		Text("the following three paragraphs in one sentence"),
+		Text(""),
+		Text("It was a dark and stormy night."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int32{1, 7, 0, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCountTokensPerPartSystemInstruction(t *testing.T) {
+	model := newFakeClient(t, &fakeServer{}).GenerativeModel("m")
+	model.SystemInstruction = &Content{Parts: []Part{Text("You are a pirate")}}
+	ctx := context.Background()
+	got, err := model.CountTokensPerPart(ctx, Text("Ahoy"), Text("where is the treasure"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int32{1, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// CountTokens still counts it.
+	res, err := model.CountTokens(ctx, Text("Ahoy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.TotalTokens, int32(5); got != want {
+		t.Errorf("CountTokens: got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// EmbeddingModel is a model that computes embeddings of text, such as
+// "text-embedding-004".
+type EmbeddingModel struct {
+	c        *Client
+	name     string
+	fullName string
+
+	// TaskType, if non-empty, is the intended use of the embeddings, like
+	// "RETRIEVAL_QUERY" or "SEMANTIC_SIMILARITY". See the documentation of
+	// the model for the supported values.
+	TaskType string
+}
+
+// EmbeddingModel creates a new instance of the named embedding model.
+func (c *Client) EmbeddingModel(name string) *EmbeddingModel {
+	return &EmbeddingModel{
+		c:        c,
+		name:     name,
+		fullName: c.fullModelName(name),
+	}
+}
+
+// Name returns the name of the model.
+func (m *EmbeddingModel) Name() string {
+	return m.name
+}
+
+// EmbedContentResponse is the response from EmbedContent.
+type EmbedContentResponse struct {
+	Embedding *ContentEmbedding
+}
+
+// BatchEmbedContentsResponse is the response from BatchEmbedContents.
+type BatchEmbedContentsResponse struct {
+	// Embeddings holds the embeddings of the contents, in order.
+	Embeddings []*ContentEmbedding
+}
+
+// ContentEmbedding is the embedding of some content.
+type ContentEmbedding struct {
+	// Values is the embedding vector.
+	Values []float32
+	// TokenCount is the number of tokens of the content.
+	TokenCount int32
+	// Truncated reports whether the content was too long and only its
+	// start was embedded.
+	Truncated bool
+}
+
+// Dimensionality returns the number of dimensions of the embedding.
+func (e *ContentEmbedding) Dimensionality() int {
+	return len(e.Values)
+}
+
+// EmbedContent returns the embedding of the content made of parts, which
+// must all be Text. Multiple parts are joined by newlines.
+func (m *EmbeddingModel) EmbedContent(ctx context.Context, parts ...Part) (*EmbedContentResponse, error) {
+	res, err := m.BatchEmbedContents(ctx, [][]Part{parts})
+	if err != nil {
+		return nil, err
+	}
+	return &EmbedContentResponse{Embedding: res.Embeddings[0]}, nil
+}
+
+// BatchEmbedContents is like EmbedContent, but returns the embeddings of
+// several contents with a single call.
+func (m *EmbeddingModel) BatchEmbedContents(ctx context.Context, contents [][]Part) (*BatchEmbedContentsResponse, error) {
+	if len(contents) == 0 {
+		return nil, errors.New("genai: no contents to embed")
+	}
+	req := &pb.PredictRequest{Endpoint: m.fullName}
+	for i, parts := range contents {
+		text, err := embeddingText(parts)
+		if err != nil {
+			return nil, fmt.Errorf("genai: content %d: %w", i, err)
+		}
+		instance := map[string]any{"content": text}
+		if m.TaskType != "" {
+			instance["task_type"] = m.TaskType
+		}
+		v, err := structpb.NewValue(instance)
+		if err != nil {
+			return nil, err
+		}
+		req.Instances = append(req.Instances, v)
+	}
+	res, err := m.c.c.Predict(ctx, req)
+	if err != nil {
+		return nil, modelNotFound(req.Endpoint, err)
+	}
+	if len(res.Predictions) != len(contents) {
+		return nil, fmt.Errorf("genai: got %d embeddings for %d contents", len(res.Predictions), len(contents))
+	}
+	return &BatchEmbedContentsResponse{Embeddings: mapSlice(res.Predictions, embeddingFromPrediction)}, nil
+}
+
+// embeddingText returns the text of parts, joined by newlines.
+func embeddingText(parts []Part) (string, error) {
+	texts := make([]string, len(parts))
+	for i, p := range parts {
+		t, ok := p.(Text)
+		if !ok {
+			return "", fmt.Errorf("only Text parts can be embedded, not %T", p)
+		}
+		texts[i] = string(t)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// embeddingFromPrediction converts a prediction of an embedding model, of the
+// form {"embeddings": {"values": [...], "statistics": {"token_count": n, "truncated": b}}}.
+func embeddingFromPrediction(p *structpb.Value) *ContentEmbedding {
+	emb := p.GetStructValue().GetFields()["embeddings"].GetStructValue().GetFields()
+	stats := emb["statistics"].GetStructValue().GetFields()
+	e := &ContentEmbedding{
+		TokenCount: int32(stats["token_count"].GetNumberValue()),
+		Truncated:  stats["truncated"].GetBoolValue(),
+	}
+	for _, v := range emb["values"].GetListValue().GetValues() {
+		e.Values = append(e.Values, float32(v.GetNumberValue()))
+	}
+	return e
+}
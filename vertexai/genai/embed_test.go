@@ -0,0 +1,96 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// fakeEmbeddingServer embeds each instance as a vector of its length and
+// number of words.
+type fakeEmbeddingServer struct {
+	pb.UnimplementedPredictionServiceServer
+	req *pb.PredictRequest
+}
+
+func (s *fakeEmbeddingServer) Predict(ctx context.Context, req *pb.PredictRequest) (*pb.PredictResponse, error) {
+	s.req = req
+	res := &pb.PredictResponse{}
+	for _, inst := range req.Instances {
+		content := inst.GetStructValue().GetFields()["content"].GetStringValue()
+		words := len(strings.Fields(content))
+		v, err := structpb.NewValue(map[string]any{
+			"embeddings": map[string]any{
+				"values":     []any{float64(len(content)), float64(words)},
+				"statistics": map[string]any{"token_count": float64(words), "truncated": false},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		res.Predictions = append(res.Predictions, v)
+	}
+	return res, nil
+}
+
+func TestEmbedContent(t *testing.T) {
+	srv := &fakeEmbeddingServer{}
+	model := newFakeClient(t, srv).EmbeddingModel("text-embedding-004")
+	model.TaskType = "RETRIEVAL_QUERY"
+	ctx := context.Background()
+
+	res, err := model.EmbedContent(ctx, Text("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ContentEmbedding{Values: []float32{11, 2}, TokenCount: 2}
+	if !reflect.DeepEqual(res.Embedding, want) {
+		t.Errorf("got %+v, want %+v", res.Embedding, want)
+	}
+	if got := res.Embedding.Dimensionality(); got != 2 {
+		t.Errorf("got dimensionality %d, want 2", got)
+	}
+	if got, want := srv.req.Endpoint, "projects/proj/locations/loc/publishers/google/models/text-embedding-004"; got != want {
+		t.Errorf("got endpoint %q, want %q", got, want)
+	}
+	if got := srv.req.Instances[0].GetStructValue().GetFields()["task_type"].GetStringValue(); got != "RETRIEVAL_QUERY" {
+		t.Errorf("got task type %q", got)
+	}
+
+	batch, err := model.BatchEmbedContents(ctx, [][]Part{{Text("a")}, {Text("b c"), Text("d")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.req.Instances); n != 2 {
+		t.Errorf("got %d instances in one call, want 2", n)
+	}
+	var got [][]float32
+	for _, e := range batch.Embeddings {
+		got = append(got, e.Values)
+	}
+	if want := [][]float32{{1, 1}, {5, 3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := model.EmbedContent(ctx, Blob{MIMEType: "image/png"}); err == nil {
+		t.Error("Blob: got nil, want error")
+	}
+}
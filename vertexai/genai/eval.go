@@ -0,0 +1,119 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// evalConcurrency is the maximum number of cases Evaluate runs at once.
+const evalConcurrency = 4
+
+// EvalCase is an input for [GenerativeModel.Evaluate].
+type EvalCase struct {
+	// Name identifies the case in the report. It is optional.
+	Name string
+	// Input is the text of the prompt.
+	Input string
+}
+
+// EvalResult is the outcome of running one EvalCase.
+type EvalResult struct {
+	Case EvalCase
+	// Output is the text of the first candidate of the response.
+	Output string
+	// Score is the result of the scorer. It is zero if Err is non-nil.
+	Score float64
+	// Latency is how long the call to the model took.
+	Latency time.Duration
+	// Err is the error from the model, if any.
+	Err error
+}
+
+// EvalReport summarizes the results of [GenerativeModel.Evaluate].
+type EvalReport struct {
+	// Results holds the result of each case, in the order of the cases.
+	Results []EvalResult
+	// MeanScore is the mean score of the cases that did not fail.
+	MeanScore float64
+	// MeanLatency and MaxLatency are computed over all the cases.
+	MeanLatency time.Duration
+	MaxLatency  time.Duration
+	// Errors is the number of cases that failed.
+	Errors int
+}
+
+// Evaluate runs the model on the input of each case and scores the output
+// with scorer, running a few cases concurrently.
+//
+// A case that fails is recorded in the report with its error rather than
+// stopping the evaluation. Evaluate only returns an error if ctx is done
+// before all the cases have run.
+func (m *GenerativeModel) Evaluate(ctx context.Context, cases []EvalCase, scorer func(input, output string) float64) (*EvalReport, error) {
+	report := &EvalReport{Results: make([]EvalResult, len(cases))}
+	sem := make(chan struct{}, evalConcurrency)
+	var wg sync.WaitGroup
+	for i, c := range cases {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+		wg.Add(1)
+		go func(i int, c EvalCase) {
+			defer func() { <-sem; wg.Done() }()
+			r := EvalResult{Case: c}
+			start := time.Now()
+			resp, err := m.GenerateContent(ctx, Text(c.Input))
+			r.Latency = time.Since(start)
+			if err != nil {
+				r.Err = err
+			} else {
+				if len(resp.Candidates) > 0 {
+					r.Output = candidateText(resp.Candidates[0])
+				}
+				r.Score = scorer(c.Input, r.Output)
+			}
+			report.Results[i] = r
+		}(i, c)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var total time.Duration
+	for _, r := range report.Results {
+		total += r.Latency
+		if r.Latency > report.MaxLatency {
+			report.MaxLatency = r.Latency
+		}
+		if r.Err != nil {
+			report.Errors++
+			continue
+		}
+		report.MeanScore += r.Score
+	}
+	if n := len(cases) - report.Errors; n > 0 {
+		report.MeanScore /= float64(n)
+	}
+	if len(cases) > 0 {
+		report.MeanLatency = total / time.Duration(len(cases))
+	}
+	return report, nil
+}
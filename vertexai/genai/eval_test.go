@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEvaluate(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("Paris"))},
+		errs:      []error{status.Error(codes.Internal, "boom")},
+	})
+	cases := []EvalCase{
+		{Name: "france", Input: "Capital of France?"},
+		{Name: "paris", Input: "Where is Paris?"},
+		{Name: "italy", Input: "Capital of Italy?"},
+		{Name: "texas", Input: "Is there a Paris in Texas?"},
+		{Name: "spain", Input: "Capital of Spain?"},
+	}
+	// Score 1 when the input mentions the output.
+	scorer := func(input, output string) float64 {
+		if strings.Contains(input, output) {
+			return 1
+		}
+		return 0
+	}
+	report, err := client.GenerativeModel("m").Evaluate(context.Background(), cases, scorer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(report.Results), len(cases); got != want {
+		t.Fatalf("got %d results, want %d", got, want)
+	}
+	var failed EvalResult
+	for i, r := range report.Results {
+		if r.Case != cases[i] {
+			t.Errorf("result %d is for case %q, want %q", i, r.Case.Name, cases[i].Name)
+		}
+		if r.Err != nil {
+			failed = r
+		} else if r.Output != "Paris" {
+			t.Errorf("%s: got output %q, want %q", r.Case.Name, r.Output, "Paris")
+		}
+		if r.Latency <= 0 || r.Latency > report.MaxLatency {
+			t.Errorf("%s: latency %v out of range (max %v)", r.Case.Name, r.Latency, report.MaxLatency)
+		}
+	}
+	if report.Errors != 1 || status.Code(failed.Err) != codes.Internal {
+		t.Fatalf("got %d errors (%v), want one Internal", report.Errors, failed.Err)
+	}
+	// Two of the four successful cases mention Paris, unless the failed
+	// case was one of them.
+	want := 2.0 / 4
+	if strings.Contains(failed.Case.Input, "Paris") {
+		want = 1.0 / 4
+	}
+	if report.MeanScore != want {
+		t.Errorf("mean score: got %v, want %v", report.MeanScore, want)
+	}
+	if report.MeanLatency <= 0 || report.MeanLatency > report.MaxLatency {
+		t.Errorf("mean latency %v out of range (max %v)", report.MeanLatency, report.MaxLatency)
+	}
+}
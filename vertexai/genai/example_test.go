@@ -22,12 +22,25 @@ import (
 	"cloud.google.com/go/vertexai/genai"
 
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 )
 
 const projectID = "your-project"
 const model = "some-model"
 const location = "some-location"
 
+func ExampleNewClient_credentials() {
+	ctx := context.Background()
+	// The contents of a service account key, from a secret manager or the
+	// environment.
+	var key []byte
+	client, err := genai.NewClient(ctx, projectID, location, option.WithCredentialsJSON(key))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+}
+
 func ExampleGenerativeModel_GenerateContent() {
 	ctx := context.Background()
 	client, err := genai.NewClient(ctx, projectID, location)
@@ -37,7 +50,7 @@ func ExampleGenerativeModel_GenerateContent() {
 	defer client.Close()
 
 	model := client.GenerativeModel(model)
-	model.Temperature = 0.9
+	model.Temperature = genai.Ptr[float32](0.9)
 	resp, err := model.GenerateContent(ctx, genai.Text("What is the average size of a swallow?"))
 	if err != nil {
 		log.Fatal(err)
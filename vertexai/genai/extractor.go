@@ -0,0 +1,52 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// extractorTemperature is the temperature of models returned by
+// JSONExtractor. It is low so that output is close to deterministic, while
+// leaving the model some room to recover from a poor first token.
+const extractorTemperature = 0.1
+
+// JSONExtractor returns a model configured for extracting structured data:
+// its output is a JSON value that conforms to schema, with no other text.
+// If schema is nil, the output is any JSON value.
+//
+// The model uses a low temperature, and its SystemInstruction tells it to
+// answer only with JSON matching the schema. This version of the API has no
+// JSON response MIME type or response schema, so the output should still be
+// validated by the caller.
+func (c *Client) JSONExtractor(model string, schema *Schema) *GenerativeModel {
+	m := c.GenerativeModel(model)
+	m.Temperature = Ptr[float32](extractorTemperature)
+	m.SystemInstruction = &Content{Parts: []Part{Text(jsonInstruction(schema))}}
+	return m
+}
+
+func jsonInstruction(schema *Schema) string {
+	const instruction = "Respond only with JSON, with no other text and no Markdown formatting."
+	if schema == nil {
+		return instruction
+	}
+	bytes, err := protojson.Marshal(schema.toProto())
+	if err != nil {
+		// A schema has no values that cannot be marshaled.
+		panic(err)
+	}
+	return instruction + " The JSON must conform to this OpenAPI schema:\n" + string(bytes) + "\n"
+}
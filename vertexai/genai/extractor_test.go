@@ -0,0 +1,57 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONExtractor(t *testing.T) {
+	schema := &Schema{
+		Type: TypeObject,
+		Properties: map[string]*Schema{
+			"name": {Type: TypeString},
+			"age":  {Type: TypeInteger},
+		},
+		Required: []string{"name"},
+	}
+	m := (&Client{}).JSONExtractor("m", schema)
+	if got, want := m.Temperature, float32(extractorTemperature); got == nil || *got != want {
+		t.Errorf("temperature: got %v, want %v", got, want)
+	}
+
+	prompt := Text("Alice is 30.")
+	req, err := m.newGenerateContentRequest(newUserContent([]Part{prompt}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.GenerationConfig.GetTemperature(), float32(extractorTemperature); got != want {
+		t.Errorf("request temperature: got %v, want %v", got, want)
+	}
+	parts := req.Contents[0].Parts
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(parts))
+	}
+	instr := parts[0].GetText()
+	for _, want := range []string{"only with JSON", `"type":"OBJECT"`, `"required":["name"]`} {
+		if !strings.Contains(strings.ReplaceAll(instr, " ", ""), strings.ReplaceAll(want, " ", "")) {
+			t.Errorf("instruction %q does not contain %q", instr, want)
+		}
+	}
+	if got := parts[1].GetText(); got != string(prompt) {
+		t.Errorf("prompt: got %q, want %q", got, prompt)
+	}
+}
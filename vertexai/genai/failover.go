@@ -0,0 +1,118 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"strings"
+
+	aiplatform "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1"
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// SetFailoverLocations sets the locations to which the calls of
+// GenerateContent, GenerateContentStream, SendMessage and SendMessageStream
+// move, in order, when the client's location is unavailable. A call moves to
+// the next location if it fails with code Unavailable before any response
+// arrives. With no locations, the default, calls do not fail over.
+//
+// The client for each location is created the first time it is needed, with
+// the options passed to NewClient, and is reused until Close. Failing over
+// happens before any retries set by SetRetry.
+// Calls in progress are not affected.
+func (c *Client) SetFailoverLocations(locations ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failover = append([]string(nil), locations...)
+}
+
+// failoverLocations returns the locations set by SetFailoverLocations.
+func (c *Client) failoverLocations() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failover
+}
+
+// regionalClient returns the client for location, creating it if needed.
+// The client outlives the call that creates it, so it is not created with the
+// call's context.
+func (c *Client) regionalClient(location string) (*aiplatform.PredictionClient, error) {
+	if location == c.location {
+		return c.c, nil
+	}
+	c.mu.Lock()
+	pc := c.regional[location]
+	c.mu.Unlock()
+	if pc != nil {
+		return pc, nil
+	}
+	// Create the client without holding c.mu, so that other calls are not
+	// held up while it connects.
+	pc, err := newPredictionClient(context.Background(), location, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if other := c.regional[location]; other != nil {
+		// Another call created one first.
+		pc.Close()
+		return other, nil
+	}
+	if c.regional == nil {
+		c.regional = map[string]*aiplatform.PredictionClient{}
+	}
+	c.regional[location] = pc
+	return pc, nil
+}
+
+// withLocation returns the resource name with its location replaced by
+// location.
+func withLocation(name, location string) string {
+	parts := strings.Split(name, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "locations" {
+			parts[i+1] = location
+			break
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// failOver moves the call to the next failover location that a client can be
+// created for, reporting whether there was one. It is called when the call
+// fails with err before any response arrives.
+func (iter *GenerateContentResponseIterator) failOver(err error) bool {
+	if status.Code(err) != codes.Unavailable {
+		return false
+	}
+	for len(iter.failover) > 0 {
+		location := iter.failover[0]
+		iter.failover = iter.failover[1:]
+		pc, err := iter.c.regionalClient(location)
+		if err != nil {
+			continue
+		}
+		req := proto.Clone(iter.req).(*pb.GenerateContentRequest)
+		req.Model = withLocation(req.Model, location)
+		iter.pc = pc
+		iter.req = req
+		return true
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFailoverLocations(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "region down")
+	srv := &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("hi"))},
+		errs:      []error{unavailable, unavailable},
+	}
+	client := newFakeClient(t, srv)
+	client.SetFailoverLocations("second", "third")
+	model := client.GenerativeModel("m")
+	ctx := context.Background()
+
+	resp, err := model.GenerateContent(ctx, Text("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := responseString(resp), "hi"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	locations := func() []string {
+		reqs, _ := srv.calls()
+		var locs []string
+		for _, r := range reqs {
+			locs = append(locs, strings.Split(r.Model, "/")[3])
+		}
+		return locs
+	}
+	if got, want := locations(), []string{"loc", "second", "third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got locations %q, want %q", got, want)
+	}
+
+	// Each call starts at the client's location, and the clients for the
+	// other locations are reused.
+	srv.mu.Lock()
+	srv.requests = nil
+	srv.errs = []error{unavailable}
+	srv.mu.Unlock()
+	if _, err := model.GenerateContent(ctx, Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := locations(), []string{"loc", "second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got locations %q, want %q", got, want)
+	}
+	client.mu.Lock()
+	n := len(client.regional)
+	client.mu.Unlock()
+	if n != 2 {
+		t.Errorf("got %d regional clients, want 2", n)
+	}
+
+	// Other errors do not fail over.
+	srv.mu.Lock()
+	srv.requests = nil
+	srv.errs = []error{status.Error(codes.InvalidArgument, "bad")}
+	srv.mu.Unlock()
+	if _, err := model.GenerateContent(ctx, Text("hello")); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got %v, want InvalidArgument", err)
+	}
+	if got, want := locations(), []string{"loc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got locations %q, want %q", got, want)
+	}
+}
+
+func TestFailoverAfterCanceledCall(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "region down")
+	srv := &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("hi"))},
+		errs:      []error{unavailable},
+	}
+	client := newFakeClient(t, srv)
+	client.SetFailoverLocations("second")
+	model := client.GenerativeModel("m")
+
+	// The first call creates the client for the failover location.
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := model.GenerateContent(ctx, Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	// The client still works after the context of that call is done.
+	srv.mu.Lock()
+	srv.errs = []error{unavailable}
+	srv.mu.Unlock()
+	resp, err := model.GenerateContent(context.Background(), Text("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := responseString(resp), "hi"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	reqs, _ := srv.calls()
+	if got, want := len(reqs), 4; got != want {
+		t.Fatalf("got %d calls, want %d", got, want)
+	}
+	if got, want := strings.Split(reqs[3].Model, "/")[3], "second"; got != want {
+		t.Errorf("got location %q, want %q", got, want)
+	}
+}
+
+func TestWithLocation(t *testing.T) {
+	got := withLocation("projects/p/locations/us-central1/publishers/google/models/m", "europe-west4")
+	if want := "projects/p/locations/europe-west4/publishers/google/models/m"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"strings"
+	"unicode"
+)
+
+// scriptLanguages maps scripts used mainly by one language to its tag.
+// Han is handled separately, since Japanese text also uses it.
+var scriptLanguages = []struct {
+	script *unicode.RangeTable
+	tag    string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// stopwords holds common short words of languages written in the Latin
+// script. A word may appear in more than one language.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "of", "to", "in", "what", "how", "you", "it", "this", "that", "with", "for"},
+	"es": {"el", "la", "los", "las", "y", "es", "de", "que", "en", "un", "una", "por", "para", "con", "cómo", "qué"},
+	"fr": {"le", "la", "les", "et", "est", "de", "des", "que", "un", "une", "pour", "avec", "dans", "qui", "vous", "je"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "mit", "für", "wie", "ich", "sie", "zu", "auf"},
+	"it": {"il", "la", "le", "e", "è", "di", "che", "un", "una", "per", "con", "come", "sono", "gli", "della"},
+	"pt": {"o", "a", "os", "as", "e", "é", "de", "que", "um", "uma", "para", "com", "não", "como", "você"},
+	"nl": {"de", "het", "een", "en", "is", "van", "dat", "niet", "met", "voor", "hoe", "ik", "je", "op", "wat"},
+}
+
+// DetectPromptLanguage guesses the language of the Text parts of a prompt,
+// returning a BCP 47 language tag like "en" or "ja", or "und" if it cannot
+// tell. It makes no API calls.
+//
+// The detector is deliberately simple. Languages with their own script are
+// recognized by it; for the Latin script, it counts common words of English,
+// Spanish, French, German, Italian, Portuguese and Dutch. Short or mixed
+// prompts may be misclassified.
+func DetectPromptLanguage(parts []Part) string {
+	var b strings.Builder
+	for _, p := range parts {
+		if a, ok := p.(AnnotatedPart); ok {
+			p = a.Part
+		}
+		if t, ok := p.(Text); ok {
+			b.WriteString(string(t))
+			b.WriteByte(' ')
+		}
+	}
+	text := b.String()
+
+	// Count the letters of each script.
+	counts := map[string]int{}
+	var latin, kana, han int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		default:
+			for _, s := range scriptLanguages {
+				if unicode.Is(s.script, r) {
+					counts[s.tag]++
+					break
+				}
+			}
+		}
+	}
+	// Japanese mixes kana with Han; Han alone is taken to be Chinese.
+	if kana > 0 {
+		counts["ja"] = kana + han
+	} else if han > 0 {
+		counts["zh"] = han
+	}
+	best, bestCount := "und", 0
+	for tag, n := range counts {
+		if n > bestCount || (n == bestCount && tag < best) {
+			best, bestCount = tag, n
+		}
+	}
+	if bestCount > latin {
+		return best
+	}
+	if latin == 0 {
+		return "und"
+	}
+	return detectLatinLanguage(text)
+}
+
+// detectLatinLanguage returns the language whose stopwords occur most often
+// in text, or "und" if none occur.
+func detectLatinLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	scores := map[string]int{}
+	for _, w := range words {
+		for tag, sw := range stopwords {
+			for _, s := range sw {
+				if w == s {
+					scores[tag]++
+					break
+				}
+			}
+		}
+	}
+	best, bestScore := "und", 0
+	for tag, n := range scores {
+		if n > bestScore || (n == bestScore && tag < best) {
+			best, bestScore = tag, n
+		}
+	}
+	return best
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import "testing"
+
+func TestDetectPromptLanguage(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want string
+	}{
+		{"What is the capital of France, and how big is it?", "en"},
+		{"¿Cuál es la capital de Francia y qué tan grande es?", "es"},
+		{"Quelle est la capitale de la France et est-elle grande pour vous ?", "fr"},
+		{"Was ist die Hauptstadt von Frankreich und wie groß ist sie?", "de"},
+		{"Qual è la capitale della Francia e come è grande?", "it"},
+		{"Wat is de hoofdstad van Frankrijk en hoe groot is het?", "nl"},
+		{"Какая столица Франции?", "ru"},
+		{"フランスの首都はどこですか？", "ja"},
+		{"法国的首都是哪里？", "zh"},
+		{"프랑스의 수도는 어디입니까?", "ko"},
+		{"ما هي عاصمة فرنسا؟", "ar"},
+		{"12345 !!!", "und"},
+	} {
+		if got := DetectPromptLanguage([]Part{Text(test.text)}); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+
+	// Non-text parts are ignored.
+	parts := []Part{ImageData("png", []byte("\x89PNG")), Text("Describe this picture, please.")}
+	if got := DetectPromptLanguage(parts); got != "en" {
+		t.Errorf("with image: got %q, want %q", got, "en")
+	}
+}
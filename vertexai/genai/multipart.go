@@ -0,0 +1,88 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+)
+
+// MaxMultipartSize is the largest request body, in bytes, that
+// PartsFromMultipart will read.
+const MaxMultipartSize = 20 << 20
+
+// PartsFromMultipart returns the parts of a prompt uploaded as a
+// multipart/form-data request.
+//
+// The values of the form fields named in textFields become Text parts, in
+// that order; fields that are absent are skipped. Every uploaded file then
+// becomes a Blob, in order of field name. The MIME type of a Blob is detected
+// from its contents, falling back to the Content-Type the client sent if
+// detection fails.
+//
+// PartsFromMultipart parses the form if r.MultipartForm is nil, returning an
+// error if the body is larger than MaxMultipartSize.
+func PartsFromMultipart(r *http.Request, textFields []string) ([]Part, error) {
+	if r.MultipartForm == nil {
+		r.Body = http.MaxBytesReader(nil, r.Body, MaxMultipartSize)
+		if err := r.ParseMultipartForm(MaxMultipartSize); err != nil {
+			return nil, fmt.Errorf("genai: parsing multipart form: %w", err)
+		}
+	}
+	var parts []Part
+	for _, name := range textFields {
+		for _, v := range r.MultipartForm.Value[name] {
+			parts = append(parts, Text(v))
+		}
+	}
+	names := make([]string, 0, len(r.MultipartForm.File))
+	for name := range r.MultipartForm.File {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, fh := range r.MultipartForm.File[name] {
+			b, err := blobFromFileHeader(fh)
+			if err != nil {
+				return nil, fmt.Errorf("genai: reading file %q of field %q: %w", fh.Filename, name, err)
+			}
+			parts = append(parts, b)
+		}
+	}
+	return parts, nil
+}
+
+func blobFromFileHeader(fh *multipart.FileHeader) (Blob, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return Blob{}, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return Blob{}, err
+	}
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if mimeType == "application/octet-stream" {
+		if t, _, err := mime.ParseMediaType(fh.Header.Get("Content-Type")); err == nil {
+			mimeType = t
+		}
+	}
+	return Blob{MIMEType: mimeType, Data: data}, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPartsFromMultipart(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("question", "What is in this picture?")
+	w.WriteField("ignored", "x")
+	w.WriteField("context", "It was taken in Rome.")
+	fw, err := w.CreateFormFile("image", "photo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(png)
+	// Undetectable content falls back to the declared type.
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="audio"; filename="clip"`)
+	h.Set("Content-Type", "audio/l16; rate=16000")
+	fw, err = w.CreatePart(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte{0x01, 0x00, 0x02, 0x00})
+	w.Close()
+
+	req := httptest.NewRequest("POST", "/", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	got, err := PartsFromMultipart(req, []string{"question", "context", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Part{
+		Text("What is in this picture?"),
+		Text("It was taken in Rome."),
+		Blob{MIMEType: "audio/l16", Data: []byte{0x01, 0x00, 0x02, 0x00}},
+		Blob{MIMEType: "image/png", Data: png},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestPartsFromMultipartTooLarge(t *testing.T) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, err := w.CreateFormFile("file", "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(strings.Repeat("x", MaxMultipartSize+1)))
+	w.Close()
+
+	req := httptest.NewRequest("POST", "/", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if _, err := PartsFromMultipart(req, nil); err == nil {
+		t.Error("got nil, want error")
+	}
+}
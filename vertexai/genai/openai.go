@@ -0,0 +1,116 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OpenAIMessage is a chat message in the shape used by OpenAI's chat API.
+// It exists to ease migrating conversations to this package.
+type OpenAIMessage struct {
+	// Role is one of "system", "user", "assistant" or "tool".
+	// The legacy "function" role is treated like "tool".
+	Role string
+	// Content is the text of the message. For tool messages, it is the
+	// output of the function. If it is a JSON object, it is used as the
+	// response directly; otherwise it is wrapped as {"content": Content}.
+	Content string
+	// Name is the name of the function that produced a tool message.
+	// It is required for tool messages and ignored otherwise.
+	Name string
+	// ToolCalls are the functions called by an assistant message. They are
+	// only allowed in assistant messages. A tool message answering a call
+	// must follow the assistant message that made it, as the model requires.
+	ToolCalls []OpenAIToolCall
+}
+
+// OpenAIToolCall is a function call made by an assistant message, in the shape
+// used by OpenAI's chat API.
+type OpenAIToolCall struct {
+	// Name is the name of the function.
+	Name string
+	// Arguments are the arguments of the call, as a JSON object.
+	Arguments string
+}
+
+// ContentsFromOpenAIMessages converts OpenAI-style chat messages to Contents.
+//
+// System messages are collected, in order, into the returned system
+// instruction, which is nil if there are none. User and assistant messages
+// become Contents with the "user" and "model" roles, the tool calls of
+// assistant messages become FunctionCall parts, and tool messages become
+// FunctionResponse parts. Consecutive messages that map to the same role are
+// combined into a single Content.
+func ContentsFromOpenAIMessages(msgs []OpenAIMessage) ([]*Content, *Content, error) {
+	var (
+		contents []*Content
+		system   *Content
+	)
+	for i, msg := range msgs {
+		if len(msg.ToolCalls) > 0 && msg.Role != "assistant" {
+			return nil, nil, fmt.Errorf("genai: message %d: %s message has tool calls", i, msg.Role)
+		}
+		var (
+			role  string
+			parts []Part
+		)
+		switch msg.Role {
+		case "system":
+			if system == nil {
+				system = &Content{}
+			}
+			system.Parts = append(system.Parts, Text(msg.Content))
+			continue
+		case "user":
+			role, parts = roleUser, []Part{Text(msg.Content)}
+		case "assistant":
+			role = roleModel
+			if msg.Content != "" || len(msg.ToolCalls) == 0 {
+				parts = append(parts, Text(msg.Content))
+			}
+			for j, tc := range msg.ToolCalls {
+				var args map[string]any
+				if err := json.Unmarshal([]byte(tc.Arguments), &args); err != nil {
+					return nil, nil, fmt.Errorf("genai: message %d: tool call %d: arguments: %w", i, j, err)
+				}
+				parts = append(parts, FunctionCall{Name: tc.Name, Args: args})
+			}
+		case "tool", "function":
+			if msg.Name == "" {
+				return nil, nil, fmt.Errorf("genai: message %d: %s message has no function name", i, msg.Role)
+			}
+			role, parts = roleFunction, []Part{FunctionResponse{Name: msg.Name, Response: openAIToolResult(msg.Content)}}
+		default:
+			return nil, nil, fmt.Errorf("genai: message %d: unknown role %q", i, msg.Role)
+		}
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+		} else {
+			contents = append(contents, &Content{Role: role, Parts: parts})
+		}
+	}
+	return contents, system, nil
+}
+
+// openAIToolResult converts the content of a tool message to a function response.
+func openAIToolResult(content string) map[string]any {
+	var m map[string]any
+	if err := json.Unmarshal([]byte(content), &m); err == nil && m != nil {
+		return m
+	}
+	return map[string]any{"content": content}
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package openai is a thin adapter that serves requests shaped like OpenAI's
+// chat completions API with the generative models of package genai.
+//
+// It is intended to ease migrating existing code. New code should use
+// package genai directly.
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// ChatCompletionMessage is a message in a chat completion request or response.
+type ChatCompletionMessage struct {
+	// Role is one of "system", "user", "assistant" or "tool".
+	Role string `json:"role"`
+	// Content is the text of the message.
+	Content string `json:"content"`
+	// Name is the name of the function that produced a tool message.
+	Name string `json:"name,omitempty"`
+}
+
+// ChatCompletionRequest is a request to CreateChatCompletion.
+type ChatCompletionRequest struct {
+	// Model is the name of the generative model, like "gemini-pro".
+	Model string `json:"model"`
+	// Messages is the conversation so far. It must end with a user or tool message.
+	Messages []ChatCompletionMessage `json:"messages"`
+	// Temperature, if non-nil, controls the randomness of the output.
+	Temperature *float32 `json:"temperature,omitempty"`
+	// TopP, if non-nil, enables nucleus sampling.
+	TopP *float32 `json:"top_p,omitempty"`
+	// MaxTokens, if positive, is the maximum number of tokens to generate.
+	MaxTokens int32 `json:"max_tokens,omitempty"`
+	// Stop contains sequences at which to stop generating.
+	Stop []string `json:"stop,omitempty"`
+}
+
+// ChatCompletionResponse is the response from CreateChatCompletion.
+type ChatCompletionResponse struct {
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   Usage                  `json:"usage"`
+}
+
+// ChatCompletionChoice is one of the responses generated by the model.
+type ChatCompletionChoice struct {
+	Index   int                   `json:"index"`
+	Message ChatCompletionMessage `json:"message"`
+	// FinishReason is one of "stop", "length" or "content_filter",
+	// or empty if the model did not report why it stopped.
+	FinishReason string `json:"finish_reason"`
+}
+
+// Usage reports the number of tokens used by a request.
+type Usage struct {
+	PromptTokens     int32 `json:"prompt_tokens"`
+	CompletionTokens int32 `json:"completion_tokens"`
+	TotalTokens      int32 `json:"total_tokens"`
+}
+
+// CreateChatCompletion generates the next message of the conversation in req
+// using client.
+//
+// System messages become the SystemInstruction of the model. The other
+// messages are sent in order, each with the role it maps to, so the last
+// message must be a user or tool message.
+func CreateChatCompletion(ctx context.Context, client *genai.Client, req ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	if len(req.Messages) == 0 {
+		return nil, errors.New("openai: no messages")
+	}
+	switch r := req.Messages[len(req.Messages)-1].Role; r {
+	case "user", "tool", "function":
+	default:
+		return nil, fmt.Errorf("openai: last message has role %q, want user or tool", r)
+	}
+	msgs := make([]genai.OpenAIMessage, len(req.Messages))
+	for i, m := range req.Messages {
+		msgs[i] = genai.OpenAIMessage{Role: m.Role, Content: m.Content, Name: m.Name}
+	}
+	contents, system, err := genai.ContentsFromOpenAIMessages(msgs)
+	if err != nil {
+		return nil, err
+	}
+	model := client.GenerativeModel(req.Model)
+	model.SystemInstruction = system
+	model.Temperature = req.Temperature
+	model.TopP = req.TopP
+	if req.MaxTokens > 0 {
+		model.MaxOutputTokens = req.MaxTokens
+	}
+	model.StopSequences = req.Stop
+
+	resp, err := model.GenerateContentFromContents(ctx, contents...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ChatCompletionResponse{
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+	}
+	for i, c := range resp.Candidates {
+		res.Choices = append(res.Choices, ChatCompletionChoice{
+			Index:        i,
+			Message:      ChatCompletionMessage{Role: "assistant", Content: c.Text()},
+			FinishReason: finishReason(c.FinishReason),
+		})
+	}
+	if u := resp.UsageMetadata; u != nil {
+		res.Usage = Usage{
+			PromptTokens:     u.PromptTokenCount,
+			CompletionTokens: u.CandidatesTokenCount,
+			TotalTokens:      u.TotalTokenCount,
+		}
+	}
+	return res, nil
+}
+
+func finishReason(r genai.FinishReason) string {
+	switch r {
+	case genai.FinishReasonStop, genai.FinishReasonOther:
+		return "stop"
+	case genai.FinishReasonMaxTokens:
+		return "length"
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation:
+		return "content_filter"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/internal/testutil"
+	"cloud.google.com/go/vertexai/genai"
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type fakeServer struct {
+	pb.UnimplementedPredictionServiceServer
+	req *pb.GenerateContentRequest
+}
+
+func (s *fakeServer) StreamGenerateContent(req *pb.GenerateContentRequest, stream pb.PredictionService_StreamGenerateContentServer) error {
+	s.req = req
+	for _, r := range []*pb.GenerateContentResponse{
+		{Candidates: []*pb.Candidate{{Content: &pb.Content{Role: "model", Parts: []*pb.Part{{Data: &pb.Part_Text{Text: "Ahoy, "}}}}}}},
+		{
+			Candidates: []*pb.Candidate{{
+				Content:      &pb.Content{Role: "model", Parts: []*pb.Part{{Data: &pb.Part_Text{Text: "matey!"}}}},
+				FinishReason: pb.Candidate_STOP,
+			}},
+			UsageMetadata: &pb.GenerateContentResponse_UsageMetadata{
+				PromptTokenCount:     7,
+				CandidatesTokenCount: 3,
+				TotalTokenCount:      10,
+			},
+		},
+	} {
+		if err := stream.Send(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCreateChatCompletion(t *testing.T) {
+	ctx := context.Background()
+	client, fake := newFakeClient(t)
+
+	temp := float32(0.5)
+	res, err := CreateChatCompletion(ctx, client, ChatCompletionRequest{
+		Model:       "gemini-pro",
+		Temperature: &temp,
+		Messages: []ChatCompletionMessage{
+			{Role: "system", Content: "Talk like a pirate."},
+			{Role: "user", Content: "Hello"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := fake.req.Model, "projects/proj/locations/loc/publishers/google/models/gemini-pro"; got != want {
+		t.Errorf("model: got %q, want %q", got, want)
+	}
+	if got, want := fake.req.GenerationConfig.GetTemperature(), temp; got != want {
+		t.Errorf("temperature: got %v, want %v", got, want)
+	}
+	if n := len(fake.req.Contents); n != 1 {
+		t.Fatalf("got %d contents, want 1", n)
+	}
+	if got := fake.req.Contents[0].Parts; len(got) != 2 || got[0].GetText() != "Talk like a pirate." || got[1].GetText() != "Hello" {
+		t.Errorf("parts: got %v", got)
+	}
+
+	want := ChatCompletionResponse{
+		Object:  "chat.completion",
+		Created: res.Created,
+		Model:   "gemini-pro",
+		Choices: []ChatCompletionChoice{{
+			Index:        0,
+			Message:      ChatCompletionMessage{Role: "assistant", Content: "Ahoy, matey!"},
+			FinishReason: "stop",
+		}},
+		Usage: Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10},
+	}
+	if diff := testutil.Diff(*res, want); diff != "" {
+		t.Errorf("mismatch (-got, +want):\n%s", diff)
+	}
+}
+
+func TestCreateChatCompletionLastMessage(t *testing.T) {
+	ctx := context.Background()
+	client, fake := newFakeClient(t)
+
+	// A tool message is sent with the function role.
+	_, err := CreateChatCompletion(ctx, client, ChatCompletionRequest{
+		Model: "gemini-pro",
+		Messages: []ChatCompletionMessage{
+			{Role: "user", Content: "What's the weather in Paris?"},
+			{Role: "assistant", Content: "Let me check."},
+			{Role: "tool", Name: "get_weather", Content: `{"temp": 21}`},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roles []string
+	for _, c := range fake.req.Contents {
+		roles = append(roles, c.Role)
+	}
+	if diff := testutil.Diff(roles, []string{"user", "model", "function"}); diff != "" {
+		t.Errorf("roles mismatch (-got, +want):\n%s", diff)
+	}
+
+	for _, msgs := range [][]ChatCompletionMessage{
+		nil,
+		{{Role: "user", Content: "Hello"}, {Role: "assistant", Content: "Hi"}},
+		{{Role: "system", Content: "Talk like a pirate."}},
+	} {
+		if _, err := CreateChatCompletion(ctx, client, ChatCompletionRequest{Model: "gemini-pro", Messages: msgs}); err == nil {
+			t.Errorf("%+v: got nil, want error", msgs)
+		}
+	}
+}
+
+func newFakeClient(t *testing.T) (*genai.Client, *fakeServer) {
+	t.Helper()
+	srv, err := testutil.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	fake := &fakeServer{}
+	pb.RegisterPredictionServiceServer(srv.Gsrv, fake)
+	srv.Start()
+
+	client, err := genai.NewClient(context.Background(), "proj", "loc",
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, fake
+}
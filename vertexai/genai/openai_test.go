@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContentsFromOpenAIMessages(t *testing.T) {
+	msgs := []OpenAIMessage{
+		{Role: "system", Content: "You are a weather bot."},
+		{Role: "user", Content: "What's the weather in Paris?"},
+		{Role: "assistant", Content: "Let me check.", ToolCalls: []OpenAIToolCall{
+			{Name: "get_weather", Arguments: `{"city": "Paris"}`},
+			{Name: "get_time", Arguments: `{}`},
+		}},
+		{Role: "tool", Name: "get_weather", Content: `{"temp": 21}`},
+		{Role: "tool", Name: "get_time", Content: "noon"},
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Thanks."},
+	}
+	gotContents, gotSystem, err := ContentsFromOpenAIMessages(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantContents := []*Content{
+		{Role: roleUser, Parts: []Part{Text("What's the weather in Paris?")}},
+		{Role: roleModel, Parts: []Part{
+			Text("Let me check."),
+			FunctionCall{Name: "get_weather", Args: map[string]any{"city": "Paris"}},
+			FunctionCall{Name: "get_time", Args: map[string]any{}},
+		}},
+		{Role: roleFunction, Parts: []Part{
+			FunctionResponse{Name: "get_weather", Response: map[string]any{"temp": 21.0}},
+			FunctionResponse{Name: "get_time", Response: map[string]any{"content": "noon"}},
+		}},
+		{Role: roleUser, Parts: []Part{Text("Thanks.")}},
+	}
+	if !reflect.DeepEqual(gotContents, wantContents) {
+		t.Errorf("contents:\ngot  %+v\nwant %+v", gotContents, wantContents)
+	}
+	wantSystem := &Content{Parts: []Part{Text("You are a weather bot."), Text("Be brief.")}}
+	if !reflect.DeepEqual(gotSystem, wantSystem) {
+		t.Errorf("system:\ngot  %+v\nwant %+v", gotSystem, wantSystem)
+	}
+
+	for _, bad := range [][]OpenAIMessage{
+		{{Role: "narrator", Content: "x"}},
+		{{Role: "tool", Content: "no name"}},
+		{{Role: "user", Content: "x", ToolCalls: []OpenAIToolCall{{Name: "f", Arguments: "{}"}}}},
+		{{Role: "assistant", ToolCalls: []OpenAIToolCall{{Name: "f", Arguments: "not json"}}}},
+	} {
+		if _, _, err := ContentsFromOpenAIMessages(bad); err == nil {
+			t.Errorf("%+v: got nil, want error", bad)
+		}
+	}
+}
+
+func TestContentsFromOpenAIMessagesToolCallsOnly(t *testing.T) {
+	got, _, err := ContentsFromOpenAIMessages([]OpenAIMessage{
+		{Role: "user", Content: "Weather?"},
+		{Role: "assistant", ToolCalls: []OpenAIToolCall{{Name: "get_weather", Arguments: `{"city": "Paris"}`}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No empty text is added to a turn with only calls.
+	want := []Part{FunctionCall{Name: "get_weather", Args: map[string]any{"city": "Paris"}}}
+	if !reflect.DeepEqual(got[1].Parts, want) {
+		t.Errorf("got %+v, want %+v", got[1].Parts, want)
+	}
+}
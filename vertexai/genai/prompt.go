@@ -0,0 +1,77 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"errors"
+)
+
+// A Prompt is a structured prompt: instructions, examples of the desired
+// behavior, and the query to answer.
+type Prompt struct {
+	// System holds instructions for the model. It is optional.
+	System string
+	// Examples are pairs of inputs and the outputs the model should give
+	// for them, in order. They are sent as earlier turns of a conversation.
+	Examples []PromptExample
+	// Query is the input to respond to.
+	Query []Part
+}
+
+// A PromptExample is an example input and output in a Prompt.
+type PromptExample struct {
+	Input  []Part
+	Output []Part
+}
+
+// Build returns the system instruction and contents of the prompt. The system
+// instruction is nil if System is empty. The contents alternate between user
+// turns, for the example inputs, and model turns, for the example outputs,
+// and end with a user turn holding the query.
+func (p *Prompt) Build() (systemInstruction *Content, contents []*Content) {
+	if p.System != "" {
+		systemInstruction = &Content{Parts: []Part{Text(p.System)}}
+	}
+	for _, ex := range p.Examples {
+		contents = append(contents,
+			&Content{Role: roleUser, Parts: ex.Input},
+			&Content{Role: roleModel, Parts: ex.Output})
+	}
+	contents = append(contents, newUserContent(p.Query))
+	return systemInstruction, contents
+}
+
+// GenerateContentFromPrompt is like GenerateContent, but for a structured
+// prompt. The system instruction of the prompt follows that of the model,
+// if any.
+func (m *GenerativeModel) GenerateContentFromPrompt(ctx context.Context, p *Prompt) (*GenerateContentResponse, error) {
+	if len(p.Query) == 0 {
+		return nil, errors.New("genai: prompt has no query")
+	}
+	system, contents := p.Build()
+	if system != nil {
+		// Send the instruction like SystemInstruction, without modifying
+		// the contents of p.
+		first := *contents[0]
+		first.Parts = append(append([]Part(nil), system.Parts...), first.Parts...)
+		contents[0] = &first
+	}
+	req, err := m.newGenerateContentRequest(contents...)
+	if err != nil {
+		return nil, err
+	}
+	return m.generateContent(ctx, req)
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+)
+
+func TestPrompt(t *testing.T) {
+	p := &Prompt{
+		System: "Classify the sentiment.",
+		Examples: []PromptExample{
+			{Input: []Part{Text("I love it.")}, Output: []Part{Text("positive")}},
+			{Input: []Part{Text("I hate it.")}, Output: []Part{Text("negative")}},
+		},
+		Query: []Part{Text("It's fine.")},
+	}
+	system, contents := p.Build()
+	if want := (&Content{Parts: []Part{Text("Classify the sentiment.")}}); !reflect.DeepEqual(system, want) {
+		t.Errorf("system: got %+v, want %+v", system, want)
+	}
+	wantContents := []*Content{
+		{Role: roleUser, Parts: []Part{Text("I love it.")}},
+		{Role: roleModel, Parts: []Part{Text("positive")}},
+		{Role: roleUser, Parts: []Part{Text("I hate it.")}},
+		{Role: roleModel, Parts: []Part{Text("negative")}},
+		{Role: roleUser, Parts: []Part{Text("It's fine.")}},
+	}
+	if !reflect.DeepEqual(contents, wantContents) {
+		t.Errorf("contents: got %+v, want %+v", contents, wantContents)
+	}
+
+	fake := &fakeServer{responses: []*pb.GenerateContentResponse{modelResponse(textPart("neutral"))}}
+	model := newFakeClient(t, fake).GenerativeModel("m")
+	model.SystemInstruction = &Content{Parts: []Part{Text("Answer in one word.")}}
+	resp, err := model.GenerateContentFromPrompt(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := responseString(resp); got != "neutral" {
+		t.Errorf("got %q, want %q", got, "neutral")
+	}
+	reqs, _ := fake.calls()
+	var got []string
+	for _, c := range reqs[0].Contents {
+		var texts []string
+		for _, p := range c.Parts {
+			texts = append(texts, p.GetText())
+		}
+		got = append(got, c.Role+": "+strings.Join(texts, "|"))
+	}
+	want := []string{
+		"user: Answer in one word.|Classify the sentiment.|I love it.",
+		"model: positive",
+		"user: I hate it.",
+		"model: negative",
+		"user: It's fine.",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("request:\ngot  %q\nwant %q", got, want)
+	}
+	if n := len(p.Examples[0].Input); n != 1 {
+		t.Errorf("prompt modified: first input has %d parts", n)
+	}
+}
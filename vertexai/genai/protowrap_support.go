@@ -25,6 +25,17 @@ func mapSlice[From, To any](from []From, f func(From) To) []To {
 	return to
 }
 
+func mapMap[K comparable, From, To any](from map[K]From, f func(From) To) map[K]To {
+	if from == nil {
+		return nil
+	}
+	to := make(map[K]To, len(from))
+	for k, v := range from {
+		to[k] = f(v)
+	}
+	return to
+}
+
 func zeroToNil[T comparable](x T) *T {
 	var z T
 	if x == z {
@@ -40,3 +51,27 @@ func nilToZero[T any](x *T) T {
 	}
 	return *x
 }
+
+func emptyToNil[T any](x []T) []T {
+	if len(x) == 0 {
+		return nil
+	}
+	return x
+}
+
+// Ptr returns a pointer to its argument, for setting optional fields such as
+// those of GenerationConfig:
+//
+//	model.Temperature = genai.Ptr[float32](0.2)
+func Ptr[T any](t T) *T {
+	return &t
+}
+
+// clonePtr returns a pointer to a copy of *p, or nil if p is nil.
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
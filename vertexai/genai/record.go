@@ -0,0 +1,123 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// RecordFormat is the format of the records written by [Client.RecordTo].
+type RecordFormat int
+
+const (
+	// RecordJSONLines writes each record as a JSON object on its own line.
+	// The object has the fields "hash", the result of HashRequest for the
+	// request; "request", the GenerateContentRequest; "responses", the
+	// GenerateContentResponses streamed by the service, in order; and, if
+	// the call failed, "code" and "message", the gRPC status of the error.
+	// Protocol buffers are in their standard JSON encoding.
+	RecordJSONLines RecordFormat = iota
+)
+
+// RecordTo makes the client write a record of each call that generates
+// content to w, for debugging and later replay. A record is written when
+// the call's stream ends, so a stream that is abandoned before its end
+// is not recorded. Errors writing to w are ignored.
+//
+// Calls may end concurrently, but records are written to w one at a time.
+// Passing a nil w stops recording.
+func (c *Client) RecordTo(w io.Writer, format RecordFormat) error {
+	if format != RecordJSONLines {
+		return fmt.Errorf("genai: unknown record format %d", format)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w == nil {
+		c.rec = nil
+	} else {
+		c.rec = &recorder{w: w}
+	}
+	return nil
+}
+
+// recorder returns the recorder of the client, or nil if it is not recording.
+func (c *Client) recorder() *recorder {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rec
+}
+
+// A callRecord is the record of a call written by a recorder.
+type callRecord struct {
+	Hash      string            `json:"hash"`
+	Request   json.RawMessage   `json:"request"`
+	Responses []json.RawMessage `json:"responses"`
+	Code      int32             `json:"code,omitempty"`
+	Message   string            `json:"message,omitempty"`
+}
+
+// A recorder writes records of calls as JSON lines.
+type recorder struct {
+	mu sync.Mutex // serializes writes to w
+	w  io.Writer
+}
+
+// record writes a record of a call with req that streamed resps and
+// then ended with err, which is nil if the call succeeded.
+func (r *recorder) record(req *pb.GenerateContentRequest, resps []*pb.GenerateContentResponse, err error) {
+	rec, merr := newCallRecord(req, resps, err)
+	if merr != nil {
+		return
+	}
+	bytes, merr := json.Marshal(rec)
+	if merr != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Write(append(bytes, '\n'))
+}
+
+func newCallRecord(req *pb.GenerateContentRequest, resps []*pb.GenerateContentResponse, err error) (*callRecord, error) {
+	hash, err2 := hashRequest(req)
+	if err2 != nil {
+		return nil, err2
+	}
+	reqJSON, err2 := protojson.Marshal(req)
+	if err2 != nil {
+		return nil, err2
+	}
+	rec := &callRecord{Hash: hash, Request: reqJSON, Responses: []json.RawMessage{}}
+	for _, resp := range resps {
+		b, err2 := protojson.Marshal(resp)
+		if err2 != nil {
+			return nil, err2
+		}
+		rec.Responses = append(rec.Responses, b)
+	}
+	if err != nil {
+		s := status.Convert(err)
+		rec.Code = int32(s.Code())
+		rec.Message = s.Message()
+	}
+	return rec, nil
+}
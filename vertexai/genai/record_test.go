@@ -0,0 +1,93 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestRecordTo(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			modelResponse(textPart("Hello, ")),
+			modelResponse(textPart("world.")),
+		},
+		errs: []error{nil, status.Error(codes.Unavailable, "down")},
+	})
+	var buf bytes.Buffer
+	if err := client.RecordTo(&buf, RecordJSONLines); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	model := client.GenerativeModel("m")
+	if _, err := model.GenerateContent(ctx, Text("Say hello.")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.GenerateContent(ctx, Text("Say it again.")); err == nil {
+		t.Fatal("got nil, want error")
+	}
+	iter := model.StartChat().SendMessageStream(ctx, Text("And once more."))
+	if _, err := all(iter); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d records, want 3:\n%s", len(lines), buf.String())
+	}
+	for i, want := range []struct {
+		prompt    string
+		responses int
+		code      codes.Code
+	}{
+		{"Say hello.", 2, codes.OK},
+		{"Say it again.", 0, codes.Unavailable},
+		{"And once more.", 2, codes.OK},
+	} {
+		var rec callRecord
+		if err := json.Unmarshal([]byte(lines[i]), &rec); err != nil {
+			t.Fatal(err)
+		}
+		var req pb.GenerateContentRequest
+		if err := protojson.Unmarshal(rec.Request, &req); err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Contents[len(req.Contents)-1].Parts[0].GetText(); got != want.prompt {
+			t.Errorf("record %d: got prompt %q, want %q", i, got, want.prompt)
+		}
+		if hash, _ := hashRequest(&req); rec.Hash != hash {
+			t.Errorf("record %d: got hash %q, want %q", i, rec.Hash, hash)
+		}
+		if got := len(rec.Responses); got != want.responses {
+			t.Errorf("record %d: got %d responses, want %d", i, got, want.responses)
+		}
+		if got := codes.Code(rec.Code); got != want.code {
+			t.Errorf("record %d: got code %v, want %v", i, got, want.code)
+		}
+	}
+
+	if err := client.RecordTo(&buf, RecordFormat(99)); err == nil {
+		t.Error("unknown format: got nil, want error")
+	}
+}
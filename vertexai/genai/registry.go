@@ -0,0 +1,75 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"sync"
+)
+
+// A ModelRegistry holds preconfigured models by name, so that code such as
+// request handlers can look them up instead of having them passed along.
+// It is safe for concurrent use.
+type ModelRegistry struct {
+	mu     sync.RWMutex
+	models map[string]*GenerativeModel
+}
+
+// NewModelRegistry returns an empty ModelRegistry.
+func NewModelRegistry() *ModelRegistry {
+	return &ModelRegistry{models: map[string]*GenerativeModel{}}
+}
+
+// Register registers m under name, replacing any model registered under it.
+func (r *ModelRegistry) Register(name string, m *GenerativeModel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.models[name] = m
+}
+
+// Model returns the model registered under name, or nil if there is none.
+func (r *ModelRegistry) Model(name string) *GenerativeModel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.models[name]
+}
+
+// defaultRegistry is the registry of RegisterModel.
+var defaultRegistry = NewModelRegistry()
+
+// RegisterModel registers m under name in the default registry, which
+// ModelFromContext uses when the context has no registry.
+func RegisterModel(name string, m *GenerativeModel) {
+	defaultRegistry.Register(name, m)
+}
+
+type registryKey struct{}
+
+// WithModelRegistry returns a copy of ctx that carries r, for ModelFromContext.
+func WithModelRegistry(ctx context.Context, r *ModelRegistry) context.Context {
+	return context.WithValue(ctx, registryKey{}, r)
+}
+
+// ModelFromContext returns the model registered under name in the registry
+// carried by ctx (see WithModelRegistry), or if ctx carries none, in the
+// default registry. It reports whether there was such a model.
+func ModelFromContext(ctx context.Context, name string) (*GenerativeModel, bool) {
+	r, _ := ctx.Value(registryKey{}).(*ModelRegistry)
+	if r == nil {
+		r = defaultRegistry
+	}
+	m := r.Model(name)
+	return m, m != nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"testing"
+)
+
+func TestModelRegistry(t *testing.T) {
+	ctx := context.Background()
+	global := &GenerativeModel{name: "global"}
+	RegisterModel("chat", global)
+	t.Cleanup(func() { defaultRegistry = NewModelRegistry() })
+
+	if m, ok := ModelFromContext(ctx, "chat"); !ok || m != global {
+		t.Errorf("default registry: got %v, %t, want the global model", m, ok)
+	}
+
+	r := NewModelRegistry()
+	scoped := &GenerativeModel{name: "scoped"}
+	r.Register("chat", scoped)
+	ctx = WithModelRegistry(ctx, r)
+	if m, ok := ModelFromContext(ctx, "chat"); !ok || m != scoped {
+		t.Errorf("context registry: got %v, %t, want the scoped model", m, ok)
+	}
+	if m, ok := ModelFromContext(ctx, "summarize"); ok || m != nil {
+		t.Errorf("unregistered: got %v, %t, want nil, false", m, ok)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// NewReplayClient returns a client that serves the calls recorded in the
+// file at path, written by a client set up with [Client.RecordTo] using
+// [RecordJSONLines]. It makes no network calls, so it is useful for hermetic
+// tests and offline development.
+//
+// A request is matched to a record by its hash (see
+// [GenerativeModel.HashRequest]), and is answered with the recorded
+// responses, or the recorded error. If the same request was recorded more
+// than once, the records are replayed in order, and the last one is repeated.
+// A request that matches no record fails with code NotFound. The project and
+// location of the client are those of the first recorded request.
+func NewReplayClient(path string) (*Client, error) {
+	srv, err := newReplayServer(path)
+	if err != nil {
+		return nil, err
+	}
+	lis := newPipeListener()
+	gsrv := grpc.NewServer()
+	pb.RegisterPredictionServiceServer(gsrv, srv)
+	go gsrv.Serve(lis)
+
+	c, err := NewClient(context.Background(), srv.projectID, srv.location,
+		option.WithEndpoint("replay"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.dial(ctx)
+		})),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		gsrv.Stop()
+		return nil, err
+	}
+	c.onClose = gsrv.Stop
+	return c, nil
+}
+
+// A pipeListener is a net.Listener for an in-process server. Its connections
+// are in-memory pipes, made by dial.
+type pipeListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// dial returns the client end of a new connection to the listener.
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		client.Close()
+		server.Close()
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		client.Close()
+		server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+// pipeAddr is the address of a pipeListener.
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// replayServer is a PredictionService that serves recorded calls.
+type replayServer struct {
+	pb.UnimplementedPredictionServiceServer
+
+	projectID, location string
+
+	mu      sync.Mutex
+	records map[string][]*callRecord // by request hash; guarded by mu
+}
+
+func newReplayServer(path string) (*replayServer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	srv := &replayServer{records: map[string][]*callRecord{}}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec callRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("genai: %s:%d: %w", path, line, err)
+		}
+		if srv.projectID == "" {
+			var req pb.GenerateContentRequest
+			if err := protojson.Unmarshal(rec.Request, &req); err != nil {
+				return nil, fmt.Errorf("genai: %s:%d: %w", path, line, err)
+			}
+			srv.projectID, srv.location = projectAndLocation(req.Model)
+		}
+		srv.records[rec.Hash] = append(srv.records[rec.Hash], &rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// projectAndLocation returns the project and location in a full model name,
+// like "projects/P/locations/L/publishers/google/models/M".
+func projectAndLocation(model string) (project, location string) {
+	parts := strings.Split(model, "/")
+	if len(parts) < 4 || parts[0] != "projects" || parts[2] != "locations" {
+		return "", ""
+	}
+	return parts[1], parts[3]
+}
+
+// next returns the record to replay for a request with hash, or nil.
+func (s *replayServer) next(hash string) *callRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recs := s.records[hash]
+	if len(recs) == 0 {
+		return nil
+	}
+	if len(recs) > 1 {
+		s.records[hash] = recs[1:]
+	}
+	return recs[0]
+}
+
+func (s *replayServer) StreamGenerateContent(req *pb.GenerateContentRequest, stream pb.PredictionService_StreamGenerateContentServer) error {
+	hash, err := hashRequest(req)
+	if err != nil {
+		return err
+	}
+	rec := s.next(hash)
+	if rec == nil {
+		return status.Errorf(codes.NotFound, "genai: no recorded call for request with hash %s", hash)
+	}
+	for _, r := range rec.Responses {
+		var resp pb.GenerateContentResponse
+		if err := protojson.Unmarshal(r, &resp); err != nil {
+			return status.Errorf(codes.Internal, "genai: bad recorded response: %v", err)
+		}
+		if err := stream.Send(&resp); err != nil {
+			return err
+		}
+	}
+	if rec.Code != 0 {
+		return status.Error(codes.Code(rec.Code), rec.Message)
+	}
+	return nil
+}
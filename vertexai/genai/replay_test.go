@@ -0,0 +1,80 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReplayClient(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "calls.jsonl")
+
+	// Record a chat session.
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			modelResponse(textPart("Bonjour")),
+			modelResponse(textPart(" !")),
+		},
+	})
+	if err := client.RecordTo(f, RecordJSONLines); err != nil {
+		t.Fatal(err)
+	}
+	session := func(client *Client) []string {
+		t.Helper()
+		cs := client.GenerativeModel("m").StartChat()
+		var got []string
+		for _, prompt := range []string{"Say hello in French.", "Again."} {
+			resp, err := cs.SendMessage(ctx, Text(prompt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, responseString(resp))
+		}
+		return got
+	}
+	recorded := session(client)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay it.
+	replay, err := NewReplayClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	if got := session(replay); !reflect.DeepEqual(got, recorded) {
+		t.Errorf("got %q, want %q", got, recorded)
+	}
+
+	// A request that wasn't recorded fails.
+	_, err = replay.GenerativeModel("m").GenerateContent(ctx, Text("Something new."))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("unmatched request: got %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"errors"
+	"fmt"
+)
+
+// requestIDKeys are the metadata keys that may hold the ID the service
+// assigns to a call, in order of preference.
+var requestIDKeys = []string{"x-goog-request-id", "x-request-id"}
+
+// RequestID returns the ID that the service assigned to the call, or the
+// empty string if the service has not sent one yet. Give it to Google
+// support when reporting a problem with the call. It is available once Next
+// has returned a response or an error.
+func (iter *GenerateContentResponseIterator) RequestID() string {
+	return iter.requestID
+}
+
+// readRequestID sets iter.requestID from the metadata of the stream, if it
+// is not set already. It must only be called after Recv has returned.
+func (iter *GenerateContentResponseIterator) readRequestID() string {
+	if iter.requestID != "" || iter.sc == nil {
+		return iter.requestID
+	}
+	// The ID may be in the trailer if the call failed without responses.
+	header, _ := iter.sc.Header()
+	for _, md := range [][]string{mdValues(header), mdValues(iter.sc.Trailer())} {
+		if len(md) > 0 {
+			iter.requestID = md[0]
+			break
+		}
+	}
+	return iter.requestID
+}
+
+// mdValues returns the values of the first of requestIDKeys present in md.
+func mdValues(md map[string][]string) []string {
+	for _, k := range requestIDKeys {
+		if vs := md[k]; len(vs) > 0 {
+			return vs
+		}
+	}
+	return nil
+}
+
+// requestIDError is an error from a call that has a request ID.
+type requestIDError struct {
+	requestID string
+	err       error
+}
+
+func (e *requestIDError) Error() string {
+	return fmt.Sprintf("%v (request ID %s)", e.err, e.requestID)
+}
+
+func (e *requestIDError) Unwrap() error { return e.err }
+
+// RequestIDFromError returns the ID the service assigned to the failed call
+// that returned err, or the empty string if there is none.
+func RequestIDFromError(err error) string {
+	var e *requestIDError
+	if errors.As(err, &e) {
+		return e.requestID
+	}
+	return ""
+}
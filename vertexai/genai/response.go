@@ -0,0 +1,217 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// refusalPrefixes are common openings of a model declining a request in prose.
+var refusalPrefixes = []string{
+	"i'm sorry, but i can",
+	"i am sorry, but i can",
+	"sorry, i can't",
+	"sorry, i cannot",
+	"i can't help with",
+	"i cannot help with",
+	"i can't assist with",
+	"i cannot assist with",
+	"i'm unable to",
+	"i am unable to",
+	"i'm not able to",
+	"i am not able to",
+	"i will not",
+	"i won't be able to",
+	"as an ai language model, i can",
+	"as a large language model, i can",
+}
+
+// IsRefusal reports whether the text of the first candidate looks like the
+// model declining to answer.
+//
+// The API does not report refusals that are written in the response text
+// rather than signaled by safety blocking, so IsRefusal is a heuristic: it
+// matches the start of the text against common phrasings of a refusal, in
+// English. It can have both false positives and false negatives, and is only
+// computed when called.
+func (r *GenerateContentResponse) IsRefusal() bool {
+	if len(r.Candidates) == 0 {
+		return false
+	}
+	text := strings.ToLower(strings.TrimSpace(candidateText(r.Candidates[0])))
+	text = strings.ReplaceAll(text, "’", "'")
+	for _, p := range refusalPrefixes {
+		if strings.HasPrefix(text, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blobs returns the Blob parts of the first candidate, in order, such as
+// images generated by the model. It returns nil if there are none.
+func (r *GenerateContentResponse) Blobs() []Blob {
+	if len(r.Candidates) == 0 || r.Candidates[0].Content == nil {
+		return nil
+	}
+	var blobs []Blob
+	for _, p := range r.Candidates[0].Content.Parts {
+		if b, ok := p.(Blob); ok {
+			blobs = append(blobs, b)
+		}
+	}
+	return blobs
+}
+
+// Text returns the concatenation of the Text parts of the first candidate,
+// ignoring other kinds of parts. It returns the empty string if there are no
+// candidates.
+func (r *GenerateContentResponse) Text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	return r.Candidates[0].Text()
+}
+
+// Text returns the concatenation of the Text parts of the candidate, ignoring
+// other kinds of parts.
+func (c *Candidate) Text() string {
+	return candidateText(c)
+}
+
+// TextWithCitations returns the text of the candidate with a citation marker
+// like "[1]" inserted at the end of each cited passage, followed by a list of
+// the cited sources, one per line, like "[1] Title: URI".
+//
+// The start and end indices of the citations are taken to be byte offsets
+// into the concatenation of the candidate's Text parts. If there are no
+// citations, TextWithCitations returns just the text.
+func (c *Candidate) TextWithCitations() string {
+	text := candidateText(c)
+	if c.CitationMetadata == nil || len(c.CitationMetadata.Citations) == 0 {
+		return text
+	}
+	cits := append([]*Citation(nil), c.CitationMetadata.Citations...)
+	sort.SliceStable(cits, func(i, j int) bool { return cits[i].EndIndex < cits[j].EndIndex })
+
+	var b strings.Builder
+	prev := 0
+	for i, cit := range cits {
+		end := int(cit.EndIndex)
+		if end > len(text) {
+			end = len(text)
+		}
+		if end < prev {
+			end = prev
+		}
+		// Don't split a multi-byte character.
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+		b.WriteString(text[prev:end])
+		fmt.Fprintf(&b, "[%d]", i+1)
+		prev = end
+	}
+	b.WriteString(text[prev:])
+	b.WriteString("\n")
+	for i, cit := range cits {
+		fmt.Fprintf(&b, "\n[%d] ", i+1)
+		switch {
+		case cit.Title != "" && cit.URI != "":
+			fmt.Fprintf(&b, "%s: %s", cit.Title, cit.URI)
+		case cit.Title != "":
+			b.WriteString(cit.Title)
+		default:
+			b.WriteString(cit.URI)
+		}
+	}
+	return b.String()
+}
+
+// Markdown returns the content of the first candidate as Markdown, or the
+// empty string if there are no candidates.
+//
+// Text parts are assumed to be Markdown already, so code the model wrote
+// in fenced blocks stays fenced. Images are rendered as image tags, with
+// inline data as data URIs; other blobs and files become links. Function
+// calls and responses are rendered as fenced JSON blocks.
+func (r *GenerateContentResponse) Markdown() string {
+	if len(r.Candidates) == 0 || r.Candidates[0].Content == nil {
+		return ""
+	}
+	var b strings.Builder
+	prevText := false
+	for _, p := range r.Candidates[0].Content.Parts {
+		if a, ok := p.(AnnotatedPart); ok {
+			p = a.Part
+		}
+		_, isText := p.(Text)
+		// Separate non-text parts from their neighbors with a blank line.
+		if b.Len() > 0 && !(isText && prevText) {
+			b.WriteString("\n\n")
+		}
+		prevText = isText
+		switch p := p.(type) {
+		case Text:
+			b.WriteString(string(p))
+		case Blob:
+			uri := "data:" + p.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+			writeMarkdownLink(&b, p.MIMEType, uri)
+		case FileData:
+			writeMarkdownLink(&b, p.MIMEType, p.FileURI)
+		case FunctionCall:
+			writeMarkdownJSON(&b, "call "+p.Name, p.Args)
+		case FunctionResponse:
+			writeMarkdownJSON(&b, "response from "+p.Name, p.Response)
+		}
+	}
+	return b.String()
+}
+
+// writeMarkdownLink writes an image tag for images, and a link otherwise.
+func writeMarkdownLink(b *strings.Builder, mimeType, uri string) {
+	if strings.HasPrefix(mimeType, "image/") {
+		b.WriteString("!")
+	}
+	fmt.Fprintf(b, "[%s](%s)", mimeType, uri)
+}
+
+// writeMarkdownJSON writes v as a fenced JSON block, preceded by a label.
+func writeMarkdownJSON(b *strings.Builder, label string, v map[string]any) {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		bytes = []byte(fmt.Sprint(v))
+	}
+	fmt.Fprintf(b, "%s:\n```json\n%s\n```", label, bytes)
+}
+
+// candidateText returns the concatenation of the Text parts of c.
+func candidateText(c *Candidate) string {
+	if c == nil || c.Content == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, p := range c.Content.Parts {
+		if t, ok := p.(Text); ok {
+			b.WriteString(string(t))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsRefusal(t *testing.T) {
+	for _, test := range []struct {
+		parts []Part
+		want  bool
+	}{
+		{[]Part{Text("I'm sorry, but I can't help with that request.")}, true},
+		{[]Part{Text("  I’m unable to "), Text("provide instructions for that.")}, true},
+		{[]Part{Text("As an AI language model, I cannot browse the web.")}, true},
+		{[]Part{Text("Swallows are about 17 cm long.")}, false},
+		{[]Part{Text("Sure. I'm sorry, but I can only estimate.")}, false},
+		{nil, false},
+	} {
+		resp := &GenerateContentResponse{
+			Candidates: []*Candidate{{Content: &Content{Role: roleModel, Parts: test.parts}}},
+		}
+		if got := resp.IsRefusal(); got != test.want {
+			t.Errorf("%v: got %t, want %t", test.parts, got, test.want)
+		}
+	}
+	if (&GenerateContentResponse{}).IsRefusal() {
+		t.Error("no candidates: got true, want false")
+	}
+}
+
+func TestBlobs(t *testing.T) {
+	cat := ImageData("png", []byte("cat"))
+	dog := ImageData("jpeg", []byte("dog"))
+	resp := &GenerateContentResponse{Candidates: []*Candidate{
+		{Content: &Content{Role: roleModel, Parts: []Part{Text("Here are two pets:"), cat, Text("and"), dog}}},
+		{Content: &Content{Role: roleModel, Parts: []Part{ImageData("png", []byte("bird"))}}},
+	}}
+	if got, want := resp.Blobs(), []Blob{cat, dog}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := (&GenerateContentResponse{}).Blobs(); got != nil {
+		t.Errorf("no candidates: got %v, want nil", got)
+	}
+}
+
+func TestText(t *testing.T) {
+	resp := &GenerateContentResponse{Candidates: []*Candidate{
+		{Content: &Content{Role: roleModel, Parts: []Part{
+			Text("A cat: "),
+			ImageData("png", []byte("cat")),
+			FunctionCall{Name: "f"},
+			Text("meow."),
+		}}},
+		{Content: &Content{Role: roleModel, Parts: []Part{Text("second")}}},
+	}}
+	if got, want := resp.Text(), "A cat: meow."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := resp.Candidates[1].Text(), "second"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := (&GenerateContentResponse{}).Text(); got != "" {
+		t.Errorf("no candidates: got %q, want empty", got)
+	}
+	if got := (&Candidate{}).Text(); got != "" {
+		t.Errorf("no content: got %q, want empty", got)
+	}
+}
+
+func TestTextWithCitations(t *testing.T) {
+	c := &Candidate{
+		Content: &Content{Role: roleModel, Parts: []Part{
+			Text("Swallows migrate south. "),
+			Text("They eat insects."),
+		}},
+		CitationMetadata: &CitationMetadata{Citations: []*Citation{
+			// Out of order, to check sorting.
+			{StartIndex: 24, EndIndex: 41, URI: "https://example.com/diet"},
+			{StartIndex: 0, EndIndex: 23, URI: "https://example.com/migration", Title: "Migration"},
+		}},
+	}
+	got := c.TextWithCitations()
+	want := "Swallows migrate south.[1] They eat insects.[2]\n" +
+		"\n[1] Migration: https://example.com/migration" +
+		"\n[2] https://example.com/diet"
+	if got != want {
+		t.Errorf("\ngot  %q\nwant %q", got, want)
+	}
+
+	c.CitationMetadata = nil
+	if got, want := c.TextWithCitations(), "Swallows migrate south. They eat insects."; got != want {
+		t.Errorf("no citations: got %q, want %q", got, want)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	r := &GenerateContentResponse{Candidates: []*Candidate{{
+		Content: &Content{Role: roleModel, Parts: []Part{
+			Text("Here is the chart:"),
+			ImageData("png", []byte("\x89PNG")),
+			Text("Made with:\n"),
+			Text("```go\nplot(data)\n```"),
+			FunctionCall{Name: "save", Args: map[string]any{"path": "chart.png"}},
+		}},
+	}}}
+	want := "Here is the chart:\n\n" +
+		"![image/png](data:image/png;base64,iVBORw==)\n\n" +
+		"Made with:\n```go\nplot(data)\n```\n\n" +
+		"call save:\n```json\n{\n  \"path\": \"chart.png\"\n}\n```"
+	if got := r.Markdown(); got != want {
+		t.Errorf("\ngot  %q\nwant %q", got, want)
+	}
+
+	if got := (&GenerateContentResponse{}).Markdown(); got != "" {
+		t.Errorf("no candidates: got %q, want empty", got)
+	}
+}
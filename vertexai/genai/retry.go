@@ -0,0 +1,156 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A RetryBudget limits the total number of retries made by all the calls
+// that share it. It is safe for concurrent use.
+type RetryBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+// NewRetryBudget returns a RetryBudget that allows n retries in total.
+func NewRetryBudget(n int) *RetryBudget {
+	return &RetryBudget{remaining: n}
+}
+
+// Remaining returns the number of retries left in the budget.
+func (b *RetryBudget) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remaining
+}
+
+// take uses one retry from the budget, reporting whether one was left.
+// A nil budget has no retries.
+func (b *RetryBudget) take() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// giveBack returns a retry taken with take that was not made.
+func (b *RetryBudget) giveBack() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining++
+}
+
+// A RetryPolicy controls how a Client retries calls that fail with a
+// transient error: one with code Unavailable, ResourceExhausted, or
+// DeadlineExceeded from the service rather than from the caller's context.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of times a call is retried.
+	MaxRetries int
+	// Backoff sets the pauses between attempts, which grow exponentially
+	// and are randomized. The zero value uses the defaults of gax.Backoff.
+	Backoff gax.Backoff
+}
+
+// SetRetry sets the policy for retrying the calls of GenerateContent,
+// GenerateContentStream, SendMessage, SendMessageStream and CountTokens.
+// A nil policy, the default, disables retries.
+//
+// A streaming call is retried only if it fails before any response arrives,
+// so that no output is repeated. A retry is not started if the deadline of
+// the call's context would pass during the pause before it.
+// Calls in progress are not affected.
+func (c *Client) SetRetry(p *RetryPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retry = p
+}
+
+// newRetryer returns a retryer for one call, or nil if the call is not
+// retried. The call is retried if the client has a retry policy or budget is
+// non-nil. If both, the retries are limited by each.
+func (c *Client) newRetryer(budget *RetryBudget) *retryer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := &retryer{budget: budget, clock: c.clock()}
+	switch {
+	case c.retry != nil:
+		r.max = c.retry.MaxRetries
+		r.bo = c.retry.Backoff
+	case budget != nil:
+		r.max = math.MaxInt
+	default:
+		return nil
+	}
+	return r
+}
+
+// A retryer tracks the retries of a single call.
+type retryer struct {
+	max     int
+	retries int
+	budget  *RetryBudget // if non-nil, each retry uses one from it
+	bo      gax.Backoff
+	clock   clock
+}
+
+// retry reports whether the call that failed with err should be retried,
+// after pausing if so. A nil retryer never retries.
+func (r *retryer) retry(ctx context.Context, err error) bool {
+	if r == nil || r.retries >= r.max || !isTransient(ctx, err) {
+		return false
+	}
+	pause := r.bo.Pause()
+	if d, ok := ctx.Deadline(); ok && d.Sub(r.clock.Now()) < pause {
+		return false
+	}
+	if r.budget != nil && !r.budget.take() {
+		return false
+	}
+	if r.clock.Sleep(ctx, pause) != nil {
+		// The retry is not made, so other calls may use it.
+		if r.budget != nil {
+			r.budget.giveBack()
+		}
+		return false
+	}
+	r.retries++
+	return true
+}
+
+// isTransient reports whether err, the result of a call made with ctx, is a
+// transient error.
+func isTransient(ctx context.Context, err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	case codes.DeadlineExceeded:
+		// Only if the service's deadline passed, not the caller's.
+		return ctx.Err() == nil
+	}
+	return false
+}
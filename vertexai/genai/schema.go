@@ -0,0 +1,96 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ParametersFromExample infers a Schema for the parameters of a
+// FunctionDeclaration from an example of the arguments, given as a JSON object.
+//
+// The types of nested objects and arrays are inferred as well. The elements of
+// an array are assumed to have the type of its first element, so arrays must
+// not be empty. Numbers without a fractional part or exponent are integers.
+// The example cannot say which properties are required, so Required is left
+// empty; set it on the result if needed.
+func ParametersFromExample(exampleJSON []byte) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(exampleJSON))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("genai: parsing example: %w", err)
+	}
+	if _, ok := v.(map[string]any); !ok {
+		return nil, errors.New("genai: example parameters must be a JSON object")
+	}
+	return schemaFromValue(v, "")
+}
+
+// schemaFromValue returns the schema of a value decoded from JSON.
+// The path is used in error messages.
+func schemaFromValue(v any, path string) (*Schema, error) {
+	switch v := v.(type) {
+	case string:
+		return &Schema{Type: TypeString}, nil
+	case bool:
+		return &Schema{Type: TypeBoolean}, nil
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return &Schema{Type: TypeInteger}, nil
+		}
+		return &Schema{Type: TypeNumber}, nil
+	case []any:
+		if len(v) == 0 {
+			return nil, fmt.Errorf("genai: %s: cannot infer the element type of an empty array", pathOrRoot(path))
+		}
+		items, err := schemaFromValue(v[0], path+"[0]")
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: TypeArray, Items: items}, nil
+	case map[string]any:
+		// Visit keys in order so errors are deterministic.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		s := &Schema{Type: TypeObject, Properties: map[string]*Schema{}}
+		for _, k := range keys {
+			p, err := schemaFromValue(v[k], path+"."+k)
+			if err != nil {
+				return nil, err
+			}
+			s.Properties[k] = p
+		}
+		return s, nil
+	case nil:
+		return nil, fmt.Errorf("genai: %s: cannot infer a type from null", pathOrRoot(path))
+	default:
+		return nil, fmt.Errorf("genai: %s: unexpected JSON value of type %T", pathOrRoot(path), v)
+	}
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "example"
+	}
+	return "example" + path
+}
@@ -0,0 +1,130 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParametersFromExample(t *testing.T) {
+	got, err := ParametersFromExample([]byte(`{
+		"location": "Paris",
+		"days": 3,
+		"threshold": 0.5,
+		"metric": true,
+		"window": {"start": "09:00", "hours": [8, 9]},
+		"stops": [{"name": "Louvre", "minutes": 90}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Schema{
+		Type: TypeObject,
+		Properties: map[string]*Schema{
+			"location":  {Type: TypeString},
+			"days":      {Type: TypeInteger},
+			"threshold": {Type: TypeNumber},
+			"metric":    {Type: TypeBoolean},
+			"window": {
+				Type: TypeObject,
+				Properties: map[string]*Schema{
+					"start": {Type: TypeString},
+					"hours": {Type: TypeArray, Items: &Schema{Type: TypeInteger}},
+				},
+			},
+			"stops": {
+				Type: TypeArray,
+				Items: &Schema{
+					Type: TypeObject,
+					Properties: map[string]*Schema{
+						"name":    {Type: TypeString},
+						"minutes": {Type: TypeInteger},
+					},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot  %+v\nwant %+v", got, want)
+	}
+
+	for _, bad := range []string{
+		`[1, 2]`,
+		`{"a": []}`,
+		`{"a": {"b": null}}`,
+		`{"a":`,
+	} {
+		if _, err := ParametersFromExample([]byte(bad)); err == nil {
+			t.Errorf("%s: got nil, want error", bad)
+		}
+	}
+}
+
+func TestSchemaRoundTrip(t *testing.T) {
+	// An object with an array of objects, two levels deep.
+	s := &Schema{
+		Type:        TypeObject,
+		Description: "An order.",
+		Properties: map[string]*Schema{
+			"id": {Type: TypeString},
+			"items": {
+				Type: TypeArray,
+				Items: &Schema{
+					Type: TypeObject,
+					Properties: map[string]*Schema{
+						"sku":      {Type: TypeString},
+						"quantity": {Type: TypeInteger},
+						"size":     {Type: TypeString, Enum: []string{"S", "M", "L"}},
+					},
+					Required: []string{"sku", "quantity"},
+				},
+			},
+		},
+		Required: []string{"id", "items"},
+	}
+	p := s.toProto()
+	if got := p.Properties["items"].Items.Properties["size"].Enum; !reflect.DeepEqual(got, []string{"S", "M", "L"}) {
+		t.Errorf("nested enum in proto: got %q", got)
+	}
+	if got := (Schema{}).fromProto(p); !reflect.DeepEqual(got, s) {
+		t.Errorf("round trip:\ngot  %+v\nwant %+v", got, s)
+	}
+}
+
+func TestSchemaNullableFormat(t *testing.T) {
+	model := &GenerativeModel{fullName: "m"}
+	model.Tools = []*Tool{{FunctionDeclarations: []*FunctionDeclaration{{
+		Name: "schedule",
+		Parameters: &Schema{
+			Type: TypeObject,
+			Properties: map[string]*Schema{
+				"start":    {Type: TypeString, Format: "date-time", Nullable: true},
+				"attendee": {Type: TypeInteger, Format: "int32"},
+			},
+		},
+	}}}}
+	req, err := model.newGenerateContentRequest(newUserContent([]Part{Text("hi")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	props := req.Tools[0].FunctionDeclarations[0].Parameters.Properties
+	if start := props["start"]; start.Format != "date-time" || !start.Nullable {
+		t.Errorf("start: got format %q, nullable %t", start.Format, start.Nullable)
+	}
+	if attendee := props["attendee"]; attendee.Format != "int32" || attendee.Nullable {
+		t.Errorf("attendee: got format %q, nullable %t", attendee.Format, attendee.Nullable)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// CheckAccess reports whether the Cloud Storage object named by f.FileURI,
+// which must have the form "gs://bucket/object", exists and can be read with
+// the credentials of client. It returns nil if so, and otherwise an error
+// wrapping the one from Cloud Storage, such as [storage.ErrObjectNotExist].
+//
+// Calls to generate content do not check access themselves; a file the
+// service cannot read makes them fail with a less specific error. Call
+// CheckAccess first, with a client that uses the same credentials as the
+// Vertex AI client, to find such problems early.
+func (f FileData) CheckAccess(ctx context.Context, client *storage.Client) error {
+	bucket, object, err := parseGCSURI(f.FileURI)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Bucket(bucket).Object(object).Attrs(ctx); err != nil {
+		return fmt.Errorf("genai: checking access to %s: %w", f.FileURI, err)
+	}
+	return nil
+}
+
+// SetStagingBucket sets the Cloud Storage bucket to which UploadFile writes,
+// and the storage client it uses. The client is not closed by Close.
+func (c *Client) SetStagingBucket(client *storage.Client, bucket string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.staging = client
+	c.stagingBucket = bucket
+}
+
+// UploadFile writes the contents of r, which have the given MIME type, to a
+// new object in the staging bucket set by SetStagingBucket, and returns a
+// FileData that refers to it. Use it for files too large to send inline as
+// a Blob.
+//
+// The object has a random name under the prefix "genai-uploads/". It is not
+// deleted by this package; consider a lifecycle rule on the bucket.
+func (c *Client) UploadFile(ctx context.Context, r io.Reader, mimeType string) (FileData, error) {
+	c.mu.Lock()
+	client, bucket := c.staging, c.stagingBucket
+	c.mu.Unlock()
+	if client == nil {
+		return FileData{}, errors.New("genai: UploadFile: no staging bucket; call SetStagingBucket")
+	}
+	if mimeType == "" {
+		return FileData{}, errors.New("genai: UploadFile: empty MIME type")
+	}
+	br := bufio.NewReader(r)
+	if _, err := br.Peek(1); err == io.EOF {
+		return FileData{}, errors.New("genai: UploadFile: empty file")
+	} else if err != nil {
+		return FileData{}, fmt.Errorf("genai: UploadFile: %w", err)
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return FileData{}, err
+	}
+	object := "genai-uploads/" + hex.EncodeToString(id[:])
+
+	// Canceling the context aborts the upload if copying fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := client.Bucket(bucket).Object(object).NewWriter(ctx)
+	w.ContentType = mimeType
+	if _, err := io.Copy(w, br); err != nil {
+		cancel()
+		w.Close()
+		return FileData{}, fmt.Errorf("genai: UploadFile: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return FileData{}, fmt.Errorf("genai: UploadFile: %w", err)
+	}
+	return FileData{MIMEType: mimeType, FileURI: "gs://" + bucket + "/" + object}, nil
+}
+
+// parseGCSURI splits a URI of the form "gs://bucket/object".
+func parseGCSURI(uri string) (bucket, object string, err error) {
+	rest, ok := strings.CutPrefix(uri, "gs://")
+	if ok {
+		bucket, object, ok = strings.Cut(rest, "/")
+	}
+	if !ok || bucket == "" || object == "" {
+		return "", "", fmt.Errorf("genai: %q is not a Cloud Storage URI of the form gs://bucket/object", uri)
+	}
+	return bucket, object, nil
+}
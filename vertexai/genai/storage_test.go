@@ -0,0 +1,116 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+func TestCheckAccess(t *testing.T) {
+	// A fake of the Cloud Storage JSON API with one readable object.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/storage/v1/b/bucket/o/cat.jpg":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"bucket": "bucket", "name": "cat.jpg", "contentType": "image/jpeg"}`))
+		case "/storage/v1/b/private/o/dog.jpg":
+			http.Error(w, `{"error": {"code": 403, "message": "forbidden"}}`, http.StatusForbidden)
+		default:
+			http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	check := func(uri string) error {
+		return FileData{MIMEType: "image/jpeg", FileURI: uri}.CheckAccess(ctx, client)
+	}
+	if err := check("gs://bucket/cat.jpg"); err != nil {
+		t.Errorf("accessible object: %v", err)
+	}
+	if err := check("gs://bucket/missing.jpg"); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Errorf("missing object: got %v, want ErrObjectNotExist", err)
+	}
+	var gerr *googleapi.Error
+	if err := check("gs://private/dog.jpg"); !errors.As(err, &gerr) || gerr.Code != http.StatusForbidden {
+		t.Errorf("unreadable object: got %v, want 403 error", err)
+	}
+	for _, uri := range []string{"https://example.com/cat.jpg", "gs://bucket", "gs:///cat.jpg"} {
+		if err := check(uri); err == nil {
+			t.Errorf("%q: got nil, want error", uri)
+		}
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	// A fake of the Cloud Storage upload API that records the uploaded object.
+	var gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotBody = r.URL.Path, string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"bucket": "staging", "name": "obj"}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	sc, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	client := &Client{}
+
+	if _, err := client.UploadFile(ctx, strings.NewReader("%PDF"), "application/pdf"); err == nil {
+		t.Error("no staging bucket: got nil, want error")
+	}
+	client.SetStagingBucket(sc, "staging")
+	fd, err := client.UploadFile(ctx, strings.NewReader("%PDF-1.7 contents"), "application/pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd.MIMEType != "application/pdf" || !strings.HasPrefix(fd.FileURI, "gs://staging/genai-uploads/") {
+		t.Errorf("got %+v", fd)
+	}
+	if !strings.HasSuffix(gotPath, "/b/staging/o") {
+		t.Errorf("uploaded to %q", gotPath)
+	}
+	for _, want := range []string{"%PDF-1.7 contents", "application/pdf", strings.TrimPrefix(fd.FileURI, "gs://staging/")} {
+		if !strings.Contains(gotBody, want) {
+			t.Errorf("upload does not contain %q:\n%s", want, gotBody)
+		}
+	}
+
+	if _, err := client.UploadFile(ctx, strings.NewReader(""), "application/pdf"); err == nil {
+		t.Error("empty reader: got nil, want error")
+	}
+	if _, err := client.UploadFile(ctx, strings.NewReader("x"), ""); err == nil {
+		t.Error("empty MIME type: got nil, want error")
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/api/iterator"
+)
+
+// ForEachText calls f with the text of the first candidate of each response
+// remaining in the stream, until the stream ends or f returns an error.
+//
+// The text is buffered so that f only sees complete UTF-8 encoded characters.
+// If wholeWords is true, f is only called with text ending in whitespace, so
+// words are never split between calls. Any text left in the buffer is passed
+// to f when the stream ends.
+//
+// ForEachText returns nil at the end of the stream, or the first error from
+// Next or f.
+func (iter *GenerateContentResponseIterator) ForEachText(wholeWords bool, f func(text string) error) error {
+	buf := textBuffer{wholeWords: wholeWords}
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			if s := buf.flush(); s != "" {
+				return f(s)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(resp.Candidates) == 0 {
+			continue
+		}
+		if s := buf.add(candidateText(resp.Candidates[0])); s != "" {
+			if err := f(s); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// chanBufferSize is the number of responses GenerateContentChan buffers
+// before it stops reading from the stream.
+const chanBufferSize = 4
+
+// GenerateContentChan is like GenerateContentStream, but delivers the
+// responses on a channel from a separate goroutine.
+//
+// Responses are sent on the first channel in the order they arrive. When the
+// stream ends, both channels are closed; if it ended with an error, that
+// error is sent on the second channel first. If ctx is done before the stream
+// ends, the call is canceled and ctx.Err() is sent. The error channel is
+// buffered, so callers that only drain responses never block the goroutine.
+//
+// The response channel holds only a few responses. When it is full, the
+// goroutine stops reading from the stream until the caller receives, so a
+// slow caller applies backpressure to the service instead of responses
+// accumulating in memory.
+func (m *GenerativeModel) GenerateContentChan(ctx context.Context, parts ...Part) (<-chan *GenerateContentResponse, <-chan error) {
+	resps := make(chan *GenerateContentResponse, chanBufferSize)
+	errc := make(chan error, 1)
+	iter := m.GenerateContentStream(ctx, parts...)
+	go sendResponses(ctx, iter.Next, func() { iter.cancel() }, resps, errc)
+	return resps, errc
+}
+
+// sendResponses sends the results of next on resps until it returns an
+// error, then sends the error on errc, unless it is [iterator.Done], and
+// closes both channels. If ctx is done while waiting to send, it calls stop
+// and sends ctx.Err() instead.
+func sendResponses(ctx context.Context, next func() (*GenerateContentResponse, error), stop func(), resps chan<- *GenerateContentResponse, errc chan<- error) {
+	defer close(errc)
+	defer close(resps)
+	for {
+		resp, err := next()
+		if err == iterator.Done {
+			return
+		}
+		if err != nil {
+			errc <- err
+			return
+		}
+		select {
+		case resps <- resp:
+		case <-ctx.Done():
+			stop()
+			errc <- ctx.Err()
+			return
+		}
+	}
+}
+
+// outputTokens returns the number of tokens generated so far, as described
+// in RemainingOutputTokens, and whether it was reported by the service.
+func (iter *GenerateContentResponseIterator) outputTokens() (n int32, exact bool) {
+	if m := iter.merged; m != nil && m.UsageMetadata != nil {
+		return m.UsageMetadata.CandidatesTokenCount, true
+	}
+	return iter.estimate, false
+}
+
+// countOutputTokens adds the estimated tokens of the first candidate of resp
+// to the running estimate, and reports the count to OnOutputTokens.
+func (iter *GenerateContentResponseIterator) countOutputTokens(resp *GenerateContentResponse) {
+	if len(resp.Candidates) > 0 {
+		iter.estimate += EstimateTokens(candidateText(resp.Candidates[0]))
+	}
+	if iter.OnOutputTokens != nil {
+		iter.OnOutputTokens(iter.outputTokens())
+	}
+}
+
+// SafetyRatings returns the latest safety ratings of the first candidate of
+// the stream, so far. Each is the last rating reported for its category;
+// categories that have not been rated yet are absent. Callers can use it to
+// react to content becoming unsafe before the stream ends.
+func (iter *GenerateContentResponseIterator) SafetyRatings() []*SafetyRating {
+	if iter.merged == nil || len(iter.merged.Candidates) == 0 {
+		return nil
+	}
+	return iter.merged.Candidates[0].SafetyRatings
+}
+
+// charsPerToken is the rough number of characters in a token, used to
+// estimate token counts when the service does not report them.
+const charsPerToken = 4
+
+// EstimateTokens returns a rough estimate of the number of tokens in text,
+// at about four characters per token, without calling the service. Use
+// [GenerativeModel.CountTokens] for an exact count.
+func EstimateTokens(text string) int32 {
+	n := utf8.RuneCountInString(text)
+	return int32((n + charsPerToken - 1) / charsPerToken)
+}
+
+// RemainingOutputTokens returns the number of tokens the model can still
+// generate for the first candidate before reaching the MaxOutputTokens of
+// the request. It is -1 if MaxOutputTokens is not set.
+//
+// The count of tokens generated so far is the CandidatesTokenCount of the
+// latest usage reported in the stream. Until the service reports usage, it
+// is the sum of [EstimateTokens] over the text of each response received.
+func (iter *GenerateContentResponseIterator) RemainingOutputTokens() int32 {
+	if iter.maxOutputTokens <= 0 {
+		return -1
+	}
+	used, _ := iter.outputTokens()
+	if used >= iter.maxOutputTokens {
+		return 0
+	}
+	return iter.maxOutputTokens - used
+}
+
+// ForEachDelta calls f for each response remaining in the stream, until the
+// stream ends or f returns an error. It passes f the delta, the text that the
+// response appended to the first candidate, along with the merged response
+// so far (see MergedResponse), whose text is the concatenation of all the
+// deltas.
+//
+// ForEachDelta returns nil at the end of the stream, or the first error from
+// Next or f.
+func (iter *GenerateContentResponseIterator) ForEachDelta(f func(delta string, merged *GenerateContentResponse) error) error {
+	var prev string
+	for {
+		_, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var text string
+		if len(iter.merged.Candidates) > 0 {
+			text = candidateText(iter.merged.Candidates[0])
+		}
+		delta := strings.TrimPrefix(text, prev)
+		prev = text
+		if err := f(delta, iter.merged); err != nil {
+			return err
+		}
+	}
+}
+
+// A textBuffer holds back the end of streamed text until it is complete.
+type textBuffer struct {
+	wholeWords bool
+	pending    string
+}
+
+// add appends s to the buffer, and removes and returns the longest prefix
+// of the buffer that can be emitted.
+func (b *textBuffer) add(s string) string {
+	b.pending += s
+	n := len(b.pending)
+	// Back up over an incomplete character at the end.
+	for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b.pending[i]) {
+			if !utf8.FullRuneInString(b.pending[i:]) {
+				n = i
+			}
+			break
+		}
+	}
+	if b.wholeWords {
+		n = strings.LastIndexFunc(b.pending[:n], unicode.IsSpace)
+		if n < 0 {
+			return ""
+		}
+		_, size := utf8.DecodeRuneInString(b.pending[n:])
+		n += size
+	}
+	out := b.pending[:n]
+	b.pending = b.pending[n:]
+	return out
+}
+
+// flush removes and returns everything in the buffer.
+func (b *textBuffer) flush() string {
+	out := b.pending
+	b.pending = ""
+	return out
+}
+
+// truncateChars cuts the text of the candidates of resp so that none goes
+// past MaxOutputChars, reporting whether any was cut.
+func (iter *GenerateContentResponseIterator) truncateChars(resp *GenerateContentResponse) bool {
+	if iter.MaxOutputChars <= 0 {
+		return false
+	}
+	if iter.chars == nil {
+		iter.chars = map[int32]int{}
+	}
+	truncated := false
+	for _, c := range resp.Candidates {
+		if c.Content == nil {
+			continue
+		}
+		for i, p := range c.Content.Parts {
+			t, ok := p.(Text)
+			if !ok {
+				continue
+			}
+			n := utf8.RuneCountInString(string(t))
+			remaining := iter.MaxOutputChars - iter.chars[c.Index]
+			if n <= remaining {
+				iter.chars[c.Index] += n
+				continue
+			}
+			c.Content.Parts = c.Content.Parts[:i:i]
+			if remaining > 0 {
+				c.Content.Parts = append(c.Content.Parts, Text(truncateRunes(string(t), remaining)))
+			}
+			iter.chars[c.Index] = iter.MaxOutputChars
+			truncated = true
+			break
+		}
+	}
+	return truncated
+}
+
+// truncateRunes returns the first n runes of s.
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// startHeartbeat starts calling OnHeartbeat if it is set and no response has
+// arrived yet. The returned function stops the calls, returning once no more
+// can happen.
+func (iter *GenerateContentResponseIterator) startHeartbeat() (stop func()) {
+	if iter.OnHeartbeat == nil || iter.HeartbeatInterval <= 0 || iter.merged != nil {
+		return func() {}
+	}
+	clk := iter.c.clock()
+	// mu is held while OnHeartbeat runs, so that stop can wait for it.
+	var (
+		mu        sync.Mutex
+		stopped   bool
+		stopTimer func() bool
+		beat      func()
+	)
+	beat = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return
+		}
+		iter.OnHeartbeat()
+		stopTimer = clk.AfterFunc(iter.HeartbeatInterval, beat)
+	}
+	mu.Lock()
+	stopTimer = clk.AfterFunc(iter.HeartbeatInterval, beat)
+	mu.Unlock()
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		stopTimer()
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+)
+
+func TestTextBuffer(t *testing.T) {
+	// "é" is two bytes, and "€" is three.
+	for _, test := range []struct {
+		wholeWords bool
+		chunks     []string
+		want       []string
+	}{
+		{
+			chunks: []string{"caf\xc3", "\xa9 au lait"},
+			want:   []string{"caf", "é au lait"},
+		},
+		{
+			chunks: []string{"5 \xe2", "\x82", "\xac!"},
+			want:   []string{"5 ", "", "€!"},
+		},
+		{
+			wholeWords: true,
+			chunks:     []string{"caf\xc3", "\xa9 au l", "ait"},
+			want:       []string{"", "café au ", "", "lait"},
+		},
+	} {
+		b := textBuffer{wholeWords: test.wholeWords}
+		var got []string
+		for _, c := range test.chunks {
+			s := b.add(c)
+			if !utf8.ValidString(s) {
+				t.Errorf("%q: emitted invalid UTF-8 %q", test.chunks, s)
+			}
+			got = append(got, s)
+		}
+		if s := b.flush(); s != "" || test.wholeWords {
+			got = append(got, s)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("wholeWords=%t, %q:\ngot  %q\nwant %q", test.wholeWords, test.chunks, got, test.want)
+		}
+	}
+}
+
+func TestForEachText(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			modelResponse(textPart("The swal")),
+			modelResponse(textPart("low flies ")),
+			modelResponse(textPart("south.")),
+		},
+	})
+	iter := client.GenerativeModel("m").GenerateContentStream(context.Background(), Text("hi"))
+	var got []string
+	err := iter.ForEachText(true, func(s string) error {
+		got = append(got, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"The ", "swallow flies ", "south."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGenerateContentChan(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			modelResponse(textPart("one")),
+			modelResponse(textPart("two")),
+		},
+	})
+	model := client.GenerativeModel("m")
+	resps, errc := model.GenerateContentChan(context.Background(), Text("count"))
+	var got []string
+	for resp := range resps {
+		got = append(got, responseString(resp))
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"one", "two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// If the caller stops receiving and cancels, the goroutine exits and
+	// reports the cancellation.
+	client = newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("one"))},
+		wait:      true,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	_, errc = client.GenerativeModel("m").GenerateContentChan(ctx, Text("count"))
+	cancel()
+	if err := <-errc; err == nil {
+		t.Error("got nil, want error")
+	}
+	if _, ok := <-errc; ok {
+		t.Error("error channel not closed")
+	}
+	if n := client.ActiveStreams(); n != 0 {
+		t.Errorf("got %d active streams, want 0", n)
+	}
+}
+
+func TestRemainingOutputTokens(t *testing.T) {
+	usage := func(n int32) *pb.GenerateContentResponse_UsageMetadata {
+		return &pb.GenerateContentResponse_UsageMetadata{CandidatesTokenCount: n}
+	}
+	first := modelResponse(textPart("The swallow "))
+	second := modelResponse(textPart("flies south "))
+	second.UsageMetadata = usage(6)
+	third := modelResponse(textPart("for the winter."))
+	third.UsageMetadata = usage(10)
+	client := newFakeClient(t, &fakeServer{responses: []*pb.GenerateContentResponse{first, second, third}})
+	model := client.GenerativeModel("m")
+	model.MaxOutputTokens = 20
+
+	iter := model.GenerateContentStream(context.Background(), Text("hi"))
+	if got := iter.RemainingOutputTokens(); got != 20 {
+		t.Errorf("before first response: got %d, want 20", got)
+	}
+	// The first count is estimated from the 12 characters of text.
+	var got []int32
+	for {
+		if _, err := iter.Next(); err != nil {
+			break
+		}
+		got = append(got, iter.RemainingOutputTokens())
+	}
+	if want := []int32{17, 14, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	model.MaxOutputTokens = 0
+	iter = model.GenerateContentStream(context.Background(), Text("hi"))
+	if got := iter.RemainingOutputTokens(); got != -1 {
+		t.Errorf("no max: got %d, want -1", got)
+	}
+}
+
+func TestSendResponsesBackpressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// Each call to next is announced on called, and returns once the test
+	// sends on proceed.
+	called := make(chan struct{})
+	proceed := make(chan struct{})
+	next := func() (*GenerateContentResponse, error) {
+		called <- struct{}{}
+		<-proceed
+		return &GenerateContentResponse{}, nil
+	}
+	stopped := make(chan struct{})
+	resps := make(chan *GenerateContentResponse, chanBufferSize)
+	errc := make(chan error, 1)
+	go sendResponses(ctx, next, func() { close(stopped) }, resps, errc)
+
+	// With no one receiving, the producer fills the buffer, reads one more
+	// response, and blocks without calling next again.
+	for i := 0; i <= chanBufferSize; i++ {
+		<-called
+		proceed <- struct{}{}
+	}
+	if n := len(resps); n != chanBufferSize {
+		t.Errorf("got %d buffered responses, want %d", n, chanBufferSize)
+	}
+	select {
+	case <-called:
+		t.Fatal("next called while the buffer is full")
+	default:
+	}
+
+	// Receiving one response lets the producer advance by one.
+	<-resps
+	<-called
+	cancel()
+	proceed <- struct{}{}
+	<-stopped
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestForEachDelta(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			modelResponse(textPart("The quick ")),
+			modelResponse(textPart("brown fox")),
+			modelResponse(textPart(" jumps.")),
+		},
+	})
+	iter := client.GenerativeModel("m").GenerateContentStream(context.Background(), Text("hi"))
+	var deltas []string
+	var full string
+	err := iter.ForEachDelta(func(delta string, merged *GenerateContentResponse) error {
+		deltas = append(deltas, delta)
+		full = responseString(merged)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"The quick ", "brown fox", " jumps."}; !reflect.DeepEqual(deltas, want) {
+		t.Errorf("got deltas %q, want %q", deltas, want)
+	}
+	if got := strings.Join(deltas, ""); got != full {
+		t.Errorf("deltas concatenate to %q, but full text is %q", got, full)
+	}
+}
+
+func TestStreamingSafetyRatings(t *testing.T) {
+	rated := func(text string, ratings ...*pb.SafetyRating) *pb.GenerateContentResponse {
+		r := modelResponse(textPart(text))
+		r.Candidates[0].SafetyRatings = ratings
+		return r
+	}
+	harassment := func(p pb.SafetyRating_HarmProbability) *pb.SafetyRating {
+		return &pb.SafetyRating{Category: pb.HarmCategory_HARM_CATEGORY_HARASSMENT, Probability: p}
+	}
+	hate := &pb.SafetyRating{Category: pb.HarmCategory_HARM_CATEGORY_HATE_SPEECH, Probability: pb.SafetyRating_NEGLIGIBLE}
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			rated("a"),
+			rated("b", harassment(pb.SafetyRating_LOW)),
+			rated("c", harassment(pb.SafetyRating_MEDIUM), hate),
+			rated("d"),
+		},
+	})
+	iter := client.GenerativeModel("m").GenerateContentStream(context.Background(), Text("hi"))
+	type rating struct {
+		c HarmCategory
+		p HarmProbability
+	}
+	for i, want := range [][]rating{
+		nil,
+		{{HarmCategoryHarassment, HarmProbabilityLow}},
+		{{HarmCategoryHarassment, HarmProbabilityMedium}, {HarmCategoryHateSpeech, HarmProbabilityNegligible}},
+		{{HarmCategoryHarassment, HarmProbabilityMedium}, {HarmCategoryHateSpeech, HarmProbabilityNegligible}},
+	} {
+		if _, err := iter.Next(); err != nil {
+			t.Fatal(err)
+		}
+		var got []rating
+		for _, r := range iter.SafetyRatings() {
+			got = append(got, rating{r.Category, r.Probability})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("after response %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestAbortAfterOutputTokens(t *testing.T) {
+	var responses []*pb.GenerateContentResponse
+	for i := int32(1); i <= 5; i++ {
+		r := modelResponse(textPart(fmt.Sprintf("chunk %d. ", i)))
+		r.UsageMetadata = &pb.GenerateContentResponse_UsageMetadata{CandidatesTokenCount: 4 * i}
+		responses = append(responses, r)
+	}
+	srv := &fakeServer{responses: responses, wait: true}
+	client := newFakeClient(t, srv)
+	iter := client.GenerativeModel("m").GenerateContentStream(context.Background(), Text("hi"))
+	iter.AbortAfterOutputTokens = 10
+
+	// The third response, at 12 tokens, crosses the threshold.
+	for i := 0; i < 3; i++ {
+		if _, err := iter.Next(); err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+	}
+	if _, err := iter.Next(); err != ErrOutputTokenLimit {
+		t.Errorf("got %v, want ErrOutputTokenLimit", err)
+	}
+	if got, want := responseString(iter.MergedResponse()), "chunk 1. chunk 2. chunk 3. "; got != want {
+		t.Errorf("partial result: got %q, want %q", got, want)
+	}
+	if n := client.ActiveStreams(); n != 0 {
+		t.Errorf("got %d active streams, want 0", n)
+	}
+}
+
+func TestOnOutputTokens(t *testing.T) {
+	last := modelResponse(textPart("for the winter."))
+	last.UsageMetadata = &pb.GenerateContentResponse_UsageMetadata{PromptTokenCount: 2, CandidatesTokenCount: 9}
+	client := newFakeClient(t, &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			modelResponse(textPart("The swallow ")),
+			modelResponse(textPart("flies south ")),
+			last,
+		},
+	})
+	iter := client.GenerativeModel("m").GenerateContentStream(context.Background(), Text("hi"))
+	type count struct {
+		n     int32
+		exact bool
+	}
+	var got []count
+	iter.OnOutputTokens = func(n int32, exact bool) { got = append(got, count{n, exact}) }
+	if _, err := all(iter); err != nil {
+		t.Fatal(err)
+	}
+	// The estimates, 3 and 6, are replaced by the service's count of 9.
+	if want := []count{{3, false}, {6, false}, {9, true}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := EstimateTokens("for the winter."), int32(4); got != want {
+		t.Errorf("EstimateTokens: got %d, want %d", got, want)
+	}
+}
+
+func TestMaxOutputChars(t *testing.T) {
+	srv := &fakeServer{
+		responses: []*pb.GenerateContentResponse{
+			modelResponse(textPart("Grüße, ")),
+			modelResponse(textPart("héllo"), textPart(" wörld")),
+			modelResponse(textPart("never sent")),
+		},
+		wait: true,
+	}
+	client := newFakeClient(t, srv)
+	iter := client.GenerativeModel("m").GenerateContentStream(context.Background(), Text("hi"))
+	iter.MaxOutputChars = 10
+
+	if _, err := iter.Next(); err != nil {
+		t.Fatal(err)
+	}
+	// The second response goes past 10 characters, in the middle of a
+	// two-byte character.
+	resp, err := iter.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Candidates[0].Content.Parts, []Part{Text("hél")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("truncated response: got %q, want %q", got, want)
+	}
+	if _, err := iter.Next(); err != ErrOutputCharLimit {
+		t.Errorf("got %v, want ErrOutputCharLimit", err)
+	}
+	got := responseString(iter.MergedResponse())
+	if want := "Grüße, hél"; got != want {
+		t.Errorf("merged: got %q, want %q", got, want)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("merged text %q is not valid UTF-8", got)
+	}
+	if n := client.ActiveStreams(); n != 0 {
+		t.Errorf("got %d active streams, want 0", n)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	srv := &fakeServer{wait: true}
+	client := newFakeClient(t, srv)
+	fc := &fakeClock{now: time.Now()}
+	client.clk = fc
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iter := client.GenerativeModel("m").GenerateContentStream(ctx, Text("hi"))
+	const interval = time.Minute
+	iter.HeartbeatInterval = interval
+	beats := make(chan struct{}, 10)
+	iter.OnHeartbeat = func() { beats <- struct{}{} }
+
+	// Beat three times while no response arrives, then end the call.
+	go func() {
+		for i := 1; i <= 3; i++ {
+			fc.awaitTimers(i)
+			fc.Advance(interval)
+			<-beats
+		}
+		cancel()
+	}()
+	if _, err := iter.Next(); err == nil {
+		t.Fatal("got nil, want error")
+	}
+	// No beats happen once Next has returned.
+	fc.Advance(3 * interval)
+	if n := len(beats); n != 0 {
+		t.Errorf("got %d heartbeats after Next returned, want none", n)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	summarizeInstruction = "Summarize the following conversation between a user and an AI model. " +
+		"Keep every fact, decision and open question that later turns may depend on. " +
+		"Respond with only the summary.\n\n"
+	summaryPrefix = "Summary of the conversation so far:\n"
+	summaryAck    = "Understood."
+)
+
+// SummarizeOldTurns shortens the history of the session by asking the model
+// to summarize all but the keepRecent most recent turns, then replacing them
+// with the summary. The summary is added as a user turn, followed by a short
+// model acknowledgment if needed to keep the roles alternating. The recent
+// turns are kept verbatim. If the oldest of them answers a FunctionCall with a
+// FunctionResponse, the turn with the call is kept too, so that the two stay
+// together.
+//
+// The call to summarize is not added to the history. If it fails, the history
+// is unchanged. If there are no more than keepRecent turns, SummarizeOldTurns
+// does nothing.
+func (cs *ChatSession) SummarizeOldTurns(ctx context.Context, keepRecent int) error {
+	if keepRecent < 0 {
+		return fmt.Errorf("genai: negative keepRecent %d", keepRecent)
+	}
+	n := len(cs.History) - keepRecent
+	for n > 0 && n < len(cs.History) && hasFunctionResponse(cs.History[n]) {
+		n--
+	}
+	if n <= 0 {
+		return nil
+	}
+	old, recent := cs.History[:n], cs.History[n:]
+
+	resp, err := cs.m.GenerateContent(ctx, Text(summarizeInstruction+transcript(old)))
+	if err != nil {
+		return err
+	}
+	if len(resp.Candidates) == 0 {
+		return errors.New("genai: no summary in response")
+	}
+	summary := strings.TrimSpace(candidateText(resp.Candidates[0]))
+	if summary == "" {
+		return errors.New("genai: empty summary")
+	}
+
+	history := []*Content{{Role: roleUser, Parts: []Part{Text(summaryPrefix + summary)}}}
+	if len(recent) == 0 || recent[0].Role != roleModel {
+		history = append(history, &Content{Role: roleModel, Parts: []Part{Text(summaryAck)}})
+	}
+	cs.History = append(history, recent...)
+	return nil
+}
+
+// hasFunctionResponse reports whether c has a FunctionResponse part.
+func hasFunctionResponse(c *Content) bool {
+	if c == nil {
+		return false
+	}
+	for _, p := range c.Parts {
+		if a, ok := p.(AnnotatedPart); ok {
+			p = a.Part
+		}
+		if _, ok := p.(FunctionResponse); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// transcript renders contents as text, one turn per paragraph.
+func transcript(contents []*Content) string {
+	var b strings.Builder
+	for _, c := range contents {
+		if c == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:", c.Role)
+		for _, p := range c.Parts {
+			if a, ok := p.(AnnotatedPart); ok {
+				p = a.Part
+			}
+			b.WriteByte(' ')
+			switch p := p.(type) {
+			case Text:
+				b.WriteString(string(p))
+			case Blob:
+				fmt.Fprintf(&b, "[%s data]", p.MIMEType)
+			case FileData:
+				fmt.Fprintf(&b, "[%s file %s]", p.MIMEType, p.FileURI)
+			case FunctionCall:
+				args, _ := json.Marshal(p.Args)
+				fmt.Fprintf(&b, "[call %s(%s)]", p.Name, args)
+			case FunctionResponse:
+				resp, _ := json.Marshal(p.Response)
+				fmt.Fprintf(&b, "[%s returned %s]", p.Name, resp)
+			}
+		}
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
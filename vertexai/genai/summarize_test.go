@@ -0,0 +1,119 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+)
+
+func TestSummarizeOldTurns(t *testing.T) {
+	srv := &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("The user is planning a trip to Rome."))},
+	}
+	cs := newFakeClient(t, srv).GenerativeModel("m").StartChat()
+	turn := func(role, text string) *Content {
+		return &Content{Role: role, Parts: []Part{Text(text)}}
+	}
+	long := []*Content{
+		turn(roleUser, "I want to travel."),
+		turn(roleModel, "Where to?"),
+		turn(roleUser, "Rome."),
+		turn(roleModel, "When?"),
+		turn(roleUser, "In May."),
+		turn(roleModel, "Great choice."),
+		turn(roleUser, "What should I see?"),
+		turn(roleModel, "The Colosseum."),
+	}
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		keepRecent int
+		want       []*Content
+	}{
+		{2, []*Content{
+			turn(roleUser, summaryPrefix+"The user is planning a trip to Rome."),
+			turn(roleModel, summaryAck),
+			long[6], long[7],
+		}},
+		{3, []*Content{
+			turn(roleUser, summaryPrefix+"The user is planning a trip to Rome."),
+			long[5], long[6], long[7],
+		}},
+		{8, long},
+	} {
+		cs.History = append([]*Content(nil), long...)
+		if err := cs.SummarizeOldTurns(ctx, test.keepRecent); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cs.History, test.want) {
+			t.Errorf("keepRecent=%d: got %d turns:\n%s", test.keepRecent, len(cs.History), transcript(cs.History))
+		}
+	}
+
+	// The first call summarized the six oldest turns.
+	reqs, _ := srv.calls()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d calls, want 2", len(reqs))
+	}
+	prompt := reqs[0].Contents[0].Parts[0].GetText()
+	if !strings.Contains(prompt, "user: In May.\n\nmodel: Great choice.") || strings.Contains(prompt, "Colosseum") {
+		t.Errorf("bad summary prompt:\n%s", prompt)
+	}
+}
+
+func TestSummarizeOldTurnsFunctionCall(t *testing.T) {
+	srv := &fakeServer{
+		responses: []*pb.GenerateContentResponse{modelResponse(textPart("The user asked about the weather."))},
+	}
+	cs := newFakeClient(t, srv).GenerativeModel("m").StartChat()
+	call := &Content{Role: roleModel, Parts: []Part{FunctionCall{Name: "weather", Args: map[string]any{"city": "Rome"}}}}
+	response := &Content{Role: roleUser, Parts: []Part{FunctionResponse{Name: "weather", Response: map[string]any{"sky": "clear"}}}}
+	answer := &Content{Role: roleModel, Parts: []Part{Text("It is clear in Rome.")}}
+	cs.History = []*Content{
+		{Role: roleUser, Parts: []Part{Text("Hi.")}},
+		{Role: roleModel, Parts: []Part{Text("Hello.")}},
+		{Role: roleUser, Parts: []Part{Text("What is the weather in Rome?")}},
+		call, response, answer,
+	}
+	// Keeping two turns would separate the call from its response.
+	if err := cs.SummarizeOldTurns(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	want := []*Content{
+		{Role: roleUser, Parts: []Part{Text(summaryPrefix + "The user asked about the weather.")}},
+		call, response, answer,
+	}
+	if !reflect.DeepEqual(cs.History, want) {
+		t.Errorf("got %d turns:\n%s", len(cs.History), transcript(cs.History))
+	}
+	reqs, _ := srv.calls()
+	if prompt := reqs[0].Contents[0].Parts[0].GetText(); strings.Contains(prompt, "[call weather") {
+		t.Errorf("call summarized:\n%s", prompt)
+	}
+
+	// With only the call and its response left to summarize, nothing is.
+	cs.History = []*Content{call, response}
+	if err := cs.SummarizeOldTurns(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(cs.History); got != 2 {
+		t.Errorf("got %d turns, want 2", got)
+	}
+}
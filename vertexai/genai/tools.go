@@ -0,0 +1,97 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// maxToolIterations bounds the number of rounds of function calls made by
+// GenerateWithTools.
+const maxToolIterations = 10
+
+// ErrTooManyToolCalls is returned by GenerateWithTools when the model keeps
+// calling functions without giving an answer.
+var ErrTooManyToolCalls = errors.New("genai: too many rounds of function calls")
+
+// GenerateWithTools is like GenerateContent, but it handles function calls
+// itself. Whenever the model calls a function, the handler of that name is
+// invoked with the call's arguments as a JSON object, and its result is sent
+// back to the model as a FunctionResponse (see [NewFunctionResponse]), in a
+// turn with the "function" role. This repeats until the model responds without
+// calling a function, and that response is returned.
+//
+// The conversation uses the first candidate of each response. After
+// maxToolIterations rounds of calls, the last response is returned along
+// with ErrTooManyToolCalls.
+func (m *GenerativeModel) GenerateWithTools(ctx context.Context, handlers map[string]func(args json.RawMessage) (any, error), parts ...Part) (*GenerateContentResponse, error) {
+	cs := m.StartChat()
+	resp, err := cs.SendMessage(ctx, parts...)
+	for i := 0; err == nil; i++ {
+		calls := functionCalls(resp)
+		if len(calls) == 0 {
+			return resp, nil
+		}
+		if i == maxToolIterations {
+			return resp, ErrTooManyToolCalls
+		}
+		var results []Part
+		for _, fc := range calls {
+			fr, err := callHandler(handlers, fc)
+			if err != nil {
+				return resp, err
+			}
+			results = append(results, fr)
+		}
+		resp, err = cs.send(ctx, &Content{Role: roleFunction, Parts: results})
+	}
+	return nil, err
+}
+
+// functionCalls returns the function calls in the first candidate of resp.
+func functionCalls(resp *GenerateContentResponse) []FunctionCall {
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return nil
+	}
+	var calls []FunctionCall
+	for _, p := range resp.Candidates[0].Content.Parts {
+		if fc, ok := p.(FunctionCall); ok {
+			calls = append(calls, fc)
+		}
+	}
+	return calls
+}
+
+func callHandler(handlers map[string]func(json.RawMessage) (any, error), fc FunctionCall) (FunctionResponse, error) {
+	h := handlers[fc.Name]
+	if h == nil {
+		return FunctionResponse{}, fmt.Errorf("genai: no handler for function %q", fc.Name)
+	}
+	args := json.RawMessage("{}")
+	if fc.Args != nil {
+		var err error
+		if args, err = json.Marshal(fc.Args); err != nil {
+			return FunctionResponse{}, fmt.Errorf("genai: arguments of function %q: %w", fc.Name, err)
+		}
+	}
+	result, err := h(args)
+	if err != nil {
+		return FunctionResponse{}, fmt.Errorf("genai: function %q: %w", fc.Name, err)
+	}
+	return NewFunctionResponse(fc.Name, result)
+}
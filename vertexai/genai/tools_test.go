@@ -0,0 +1,88 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestGenerateWithTools(t *testing.T) {
+	args, err := structpb.NewStruct(map[string]any{"location": "Paris"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := modelResponse(&pb.Part{Data: &pb.Part_FunctionCall{FunctionCall: &pb.FunctionCall{Name: "get_weather", Args: args}}})
+	srv := &fakeServer{turns: [][]*pb.GenerateContentResponse{
+		{call},
+		{modelResponse(textPart("It is 21 degrees in Paris."))},
+	}}
+	model := newFakeClient(t, srv).GenerativeModel("m")
+	var gotArgs string
+	handlers := map[string]func(json.RawMessage) (any, error){
+		"get_weather": func(args json.RawMessage) (any, error) {
+			gotArgs = string(args)
+			return struct {
+				Temp float64 `json:"temp"`
+			}{21}, nil
+		},
+	}
+	resp, err := model.GenerateWithTools(context.Background(), handlers, Text("What's the weather in Paris?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := responseString(resp), "It is 21 degrees in Paris."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if want := `{"location":"Paris"}`; gotArgs != want {
+		t.Errorf("handler args: got %s, want %s", gotArgs, want)
+	}
+	reqs, _ := srv.calls()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d calls, want 2", len(reqs))
+	}
+	contents := reqs[1].Contents
+	if n := len(contents); n != 3 {
+		t.Fatalf("got %d contents, want 3", n)
+	}
+	if got := contents[2].Role; got != roleFunction {
+		t.Errorf("got role %q, want %q", got, roleFunction)
+	}
+	if fr := contents[2].Parts[0].GetFunctionResponse(); fr.GetName() != "get_weather" || fr.Response.Fields["temp"].GetNumberValue() != 21 {
+		t.Errorf("got %v, want the function response", contents[2].Parts[0])
+	}
+
+	// A model that never stops calling functions is cut off.
+	srv.mu.Lock()
+	srv.responses = []*pb.GenerateContentResponse{call}
+	srv.mu.Unlock()
+	resp, err = model.GenerateWithTools(context.Background(), handlers, Text("What's the weather in Paris?"))
+	if !errors.Is(err, ErrTooManyToolCalls) {
+		t.Errorf("got %v, want ErrTooManyToolCalls", err)
+	}
+	if resp == nil {
+		t.Error("got nil response, want the last one")
+	}
+
+	// A call to an unknown function is an error.
+	if _, err := model.GenerateWithTools(context.Background(), nil, Text("hi")); err == nil {
+		t.Error("no handlers: got nil, want error")
+	}
+}
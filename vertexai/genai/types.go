@@ -0,0 +1,262 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The types in this file wrap messages of the API, like those in
+// aiplatformpb_wrapper.gen.go, but are written by hand: protowrap does not
+// generate them, or generates them differently.
+
+package genai
+
+import (
+	pb "cloud.google.com/go/vertexai/internal/aiplatform/apiv1beta1/aiplatformpb"
+)
+
+// FunctionDeclaration is structured representation of a function declaration as defined by the
+// [OpenAPI 3.0 specification](https://spec.openapis.org/oas/v3.0.3). Included
+// in this declaration are the function name and parameters. This
+// FunctionDeclaration is a representation of a block of code that can be used
+// as a `Tool` by the model and executed by the client.
+type FunctionDeclaration struct {
+	// Required. The name of the function to call.
+	// Must start with a letter or an underscore.
+	// Must be a-z, A-Z, 0-9, or contain underscores and dashes, with a maximum
+	// length of 64.
+	Name string
+	// Optional. Description and purpose of the function.
+	// Model uses it to decide how and whether to call the function.
+	Description string
+	// Optional. Describes the parameters to this function in JSON Schema Object
+	// format. Reflects the Open API 3.03 Parameter Object. string Key: the name
+	// of the parameter. Parameter names are case sensitive. Schema Value: the
+	// Schema defining the type used for the parameter. For function with no
+	// parameters, this can be left unset.
+	Parameters *Schema
+}
+
+func (w *FunctionDeclaration) toProto() *pb.FunctionDeclaration {
+	if w == nil {
+		return nil
+	}
+	return &pb.FunctionDeclaration{
+		Name:        w.Name,
+		Description: w.Description,
+		Parameters:  w.Parameters.toProto(),
+	}
+}
+
+func (FunctionDeclaration) fromProto(p *pb.FunctionDeclaration) *FunctionDeclaration {
+	if p == nil {
+		return nil
+	}
+	return &FunctionDeclaration{
+		Name:        p.Name,
+		Description: p.Description,
+		Parameters:  (Schema{}).fromProto(p.Parameters),
+	}
+}
+
+// GenerationConfig is generation config.
+type GenerationConfig struct {
+	// Optional. Controls the randomness of predictions.
+	// If nil, the model's default is used. See [Ptr].
+	Temperature *float32
+	// Optional. If specified, nucleus sampling will be used.
+	TopP *float32
+	// Optional. If specified, top-k sampling will be used.
+	TopK *float32
+	// Optional. Number of candidates to generate.
+	CandidateCount int32
+	// Optional. The maximum number of output tokens to generate per message.
+	MaxOutputTokens int32
+	// Optional. Stop sequences.
+	StopSequences []string
+}
+
+func (w *GenerationConfig) toProto() *pb.GenerationConfig {
+	if w == nil {
+		return nil
+	}
+	// Copy the pointers and slice, so that changes to w made with
+	// GenerativeModel.Configure do not affect requests in flight.
+	return &pb.GenerationConfig{
+		Temperature:     clonePtr(w.Temperature),
+		TopP:            clonePtr(w.TopP),
+		TopK:            clonePtr(w.TopK),
+		CandidateCount:  zeroToNil(w.CandidateCount),
+		MaxOutputTokens: zeroToNil(w.MaxOutputTokens),
+		StopSequences:   emptyToNil(append([]string(nil), w.StopSequences...)),
+	}
+}
+
+func (GenerationConfig) fromProto(p *pb.GenerationConfig) *GenerationConfig {
+	if p == nil {
+		return nil
+	}
+	return &GenerationConfig{
+		Temperature:     clonePtr(p.Temperature),
+		TopP:            clonePtr(p.TopP),
+		TopK:            clonePtr(p.TopK),
+		CandidateCount:  nilToZero(p.CandidateCount),
+		MaxOutputTokens: nilToZero(p.MaxOutputTokens),
+		StopSequences:   append([]string(nil), p.StopSequences...),
+	}
+}
+
+// Schema is used to define the format of input/output data. Represents a select
+// subset of an [OpenAPI 3.0 schema
+// object](https://spec.openapis.org/oas/v3.0.3#schema). More fields may be
+// added in the future as needed.
+type Schema struct {
+	// Optional. The type of the data.
+	Type Type
+	// Optional. The format of the data.
+	// Supported formats:
+	//
+	//	for NUMBER type: float, double
+	//	for INTEGER type: int32, int64
+	Format string
+	// Optional. The description of the data.
+	Description string
+	// Optional. Indicates if the value may be null.
+	Nullable bool
+	// Optional. Schema of the elements of Type.ARRAY.
+	Items *Schema
+	// Optional. Possible values of the element of Type.STRING with enum format.
+	// For example we can define an Enum Direction as :
+	// {type:STRING, format:enum, enum:["EAST", NORTH", "SOUTH", "WEST"]}
+	Enum []string
+	// Optional. Properties of Type.OBJECT.
+	Properties map[string]*Schema
+	// Optional. Required properties of Type.OBJECT.
+	Required []string
+}
+
+func (w *Schema) toProto() *pb.Schema {
+	if w == nil {
+		return nil
+	}
+	return &pb.Schema{
+		Type:        pb.Type(w.Type),
+		Format:      w.Format,
+		Description: w.Description,
+		Nullable:    w.Nullable,
+		Items:       w.Items.toProto(),
+		Enum:        w.Enum,
+		Properties:  mapMap(w.Properties, (*Schema).toProto),
+		Required:    w.Required,
+	}
+}
+
+func (Schema) fromProto(p *pb.Schema) *Schema {
+	if p == nil {
+		return nil
+	}
+	return &Schema{
+		Type:        Type(p.Type),
+		Format:      p.Format,
+		Description: p.Description,
+		Nullable:    p.Nullable,
+		Items:       (Schema{}).fromProto(p.Items),
+		Enum:        p.Enum,
+		Properties:  mapMap(p.Properties, (Schema{}).fromProto),
+		Required:    p.Required,
+	}
+}
+
+// Tool details that the model may use to generate response.
+//
+// A `Tool` is a piece of code that enables the system to interact with
+// external systems to perform an action, or set of actions, outside of
+// knowledge and scope of the model.
+type Tool struct {
+	// Optional. One or more function declarations to be passed to the model along
+	// with the current user query. Model may decide to call a subset of these
+	// functions by populating [FunctionCall][content.part.function_call] in the
+	// response. User should provide a
+	// [FunctionResponse][content.part.function_response] for each function call
+	// in the next turn. Based on the function responses, Model will generate the
+	// final response back to the user. Maximum 64 function declarations can be
+	// provided.
+	FunctionDeclarations []*FunctionDeclaration
+}
+
+func (w *Tool) toProto() *pb.Tool {
+	if w == nil {
+		return nil
+	}
+	return &pb.Tool{
+		FunctionDeclarations: mapSlice(w.FunctionDeclarations, (*FunctionDeclaration).toProto),
+	}
+}
+
+func (Tool) fromProto(p *pb.Tool) *Tool {
+	if p == nil {
+		return nil
+	}
+	return &Tool{
+		FunctionDeclarations: mapSlice(p.FunctionDeclarations, (FunctionDeclaration{}).fromProto),
+	}
+}
+
+// Type contains the list of OpenAPI data types as defined by
+// https://swagger.io/docs/specification/data-models/data-types/
+type Type int32
+
+const (
+	// TypeUnspecified means not specified, should not be used.
+	TypeUnspecified Type = 0
+	// TypeString means openAPI string type
+	TypeString Type = 1
+	// TypeNumber means openAPI number type
+	TypeNumber Type = 2
+	// TypeInteger means openAPI integer type
+	TypeInteger Type = 3
+	// TypeBoolean means openAPI boolean type
+	TypeBoolean Type = 4
+	// TypeArray means openAPI array type
+	TypeArray Type = 5
+	// TypeObject means openAPI object type
+	TypeObject Type = 6
+)
+
+// UsageMetadata is usage metadata about response(s).
+type UsageMetadata struct {
+	// Number of tokens in the request.
+	PromptTokenCount int32
+	// Number of tokens in the response(s).
+	CandidatesTokenCount int32
+	TotalTokenCount      int32
+}
+
+func (w *UsageMetadata) toProto() *pb.GenerateContentResponse_UsageMetadata {
+	if w == nil {
+		return nil
+	}
+	return &pb.GenerateContentResponse_UsageMetadata{
+		PromptTokenCount:     w.PromptTokenCount,
+		CandidatesTokenCount: w.CandidatesTokenCount,
+		TotalTokenCount:      w.TotalTokenCount,
+	}
+}
+
+func (UsageMetadata) fromProto(p *pb.GenerateContentResponse_UsageMetadata) *UsageMetadata {
+	if p == nil {
+		return nil
+	}
+	return &UsageMetadata{
+		PromptTokenCount:     p.PromptTokenCount,
+		CandidatesTokenCount: p.CandidatesTokenCount,
+		TotalTokenCount:      p.TotalTokenCount,
+	}
+}